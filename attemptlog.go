@@ -0,0 +1,102 @@
+package retryhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// redactedValue replaces the values of redacted headers when an [Attempt] is serialized.
+const redactedValue = "[REDACTED]"
+
+// DefaultRedactedHeaders are the headers whose values are redacted when an [Attempt] is
+// serialized, unless others are configured with [WithRedactedHeaders].
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// attemptRecord is the serialized form of an Attempt. It holds no body streams, so
+// serializing an Attempt never reads or closes a body.
+type attemptRecord struct {
+	Count      int             `json:"count"`
+	Reason     RetryReason     `json:"reason,omitempty"`
+	Overloaded bool            `json:"overloaded"`
+	LastDelay  string          `json:"last_delay,omitempty"`
+	Err        string          `json:"error,omitempty"`
+	Req        *requestRecord  `json:"request,omitempty"`
+	Res        *responseRecord `json:"response,omitempty"`
+	lastDelay  time.Duration
+}
+
+type requestRecord struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+type responseRecord struct {
+	StatusCode    int         `json:"status"`
+	Header        http.Header `json:"header,omitempty"`
+	ContentLength int64       `json:"content_length"`
+}
+
+// MarshalJSON serializes the attempt for logging or a debugging endpoint. The request and
+// response are reduced to their method, URL, status, and headers; their bodies are never
+// touched, and the password in the URL as well as the values of sensitive headers are
+// redacted. The headers redacted are [DefaultRedactedHeaders], or the ones configured with
+// [WithRedactedHeaders] for attempts created by [Transport]. Values is omitted.
+func (a Attempt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.record())
+}
+
+func (a Attempt) record() attemptRecord {
+	redacted := a.redactedHeaders
+	if redacted == nil {
+		redacted = DefaultRedactedHeaders
+	}
+
+	r := attemptRecord{
+		Count:      a.Count,
+		Reason:     a.Reason,
+		Overloaded: a.Overloaded,
+		lastDelay:  a.LastDelay,
+	}
+	if a.LastDelay > 0 {
+		r.LastDelay = a.LastDelay.String()
+	}
+	if a.Err != nil {
+		r.Err = a.Err.Error()
+	}
+	if a.Req != nil {
+		r.Req = &requestRecord{
+			Method: a.Req.Method,
+			Header: redactHeader(a.Req.Header, redacted),
+		}
+		if a.Req.URL != nil {
+			r.Req.URL = a.Req.URL.Redacted()
+		}
+	}
+	if a.Res != nil {
+		r.Res = &responseRecord{
+			StatusCode:    a.Res.StatusCode,
+			Header:        redactHeader(a.Res.Header, redacted),
+			ContentLength: a.Res.ContentLength,
+		}
+	}
+
+	return r
+}
+
+// redactHeader returns a copy of header with the values of the redacted headers replaced.
+func redactHeader(header http.Header, redacted []string) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+
+	out := header.Clone()
+	for _, name := range redacted {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := out[name]; ok {
+			out[name] = []string{redactedValue}
+		}
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+//go:build go1.21
+// +build go1.21
+
+package retryhttp
+
+import "log/slog"
+
+// LogValue implements [slog.LogValuer], so that an attempt passed to a structured logger is
+// logged as a group of its fields, redacted the same way as by [Attempt.MarshalJSON].
+func (a Attempt) LogValue() slog.Value {
+	r := a.record()
+
+	attrs := []slog.Attr{
+		slog.Int("count", r.Count),
+		slog.Bool("overloaded", r.Overloaded),
+	}
+	if r.Reason != "" {
+		attrs = append(attrs, slog.String("reason", string(r.Reason)))
+	}
+	if r.lastDelay > 0 {
+		attrs = append(attrs, slog.Duration("last_delay", r.lastDelay))
+	}
+	if r.Err != "" {
+		attrs = append(attrs, slog.String("error", r.Err))
+	}
+	if r.Req != nil {
+		attrs = append(attrs, slog.Group("request",
+			slog.String("method", r.Req.Method),
+			slog.String("url", r.Req.URL),
+			slog.Any("header", r.Req.Header),
+		))
+	}
+	if r.Res != nil {
+		attrs = append(attrs, slog.Group("response",
+			slog.Int("status", r.Res.StatusCode),
+			slog.Any("header", r.Res.Header),
+			slog.Int64("content_length", r.Res.ContentLength),
+		))
+	}
+
+	return slog.GroupValue(attrs...)
+}
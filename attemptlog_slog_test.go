@@ -0,0 +1,64 @@
+//go:build go1.21
+// +build go1.21
+
+package retryhttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+func TestAttemptLogValue(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/path", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-Id", "abc")
+
+	body := &trackingBody{Reader: strings.NewReader("secret payload")}
+	attempt := retryhttp.Attempt{
+		Count: 2,
+		Req:   req,
+		Res:   &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}, Body: body},
+		Err:   errors.New("boom"),
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("attempt", "attempt", attempt)
+
+	var got struct {
+		Attempt struct {
+			Count   int    `json:"count"`
+			Error   string `json:"error"`
+			Request struct {
+				Header http.Header `json:"header"`
+			} `json:"request"`
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+		} `json:"attempt"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding %s: %s", buf.Bytes(), err)
+	}
+	if got.Attempt.Count != 2 || got.Attempt.Error != "boom" || got.Attempt.Response.Status != http.StatusBadGateway {
+		t.Errorf("unexpected logged attempt: %s", buf.Bytes())
+	}
+	if v := got.Attempt.Request.Header.Get("Authorization"); v != "[REDACTED]" {
+		t.Errorf("expected Authorization to be redacted but got %q", v)
+	}
+	if v := got.Attempt.Request.Header.Get("X-Request-Id"); v != "abc" {
+		t.Errorf("expected X-Request-Id to be visible but got %q", v)
+	}
+	if strings.Contains(buf.String(), "payload") || body.read != 0 {
+		t.Errorf("expected the response body to be left unread: %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,83 @@
+package retryhttp
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+)
+
+// bufferBudget limits how many bytes of request bodies are buffered into memory at once
+// across all of a [Transport]'s in-flight requests. See [WithMaxBufferedBytes].
+type bufferBudget struct {
+	used  int64 // accessed atomically, and first in the struct so it is 64-bit aligned
+	limit int64
+}
+
+// reserve claims n bytes of the budget if that many are available.
+func (b *bufferBudget) reserve(n int64) bool {
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+n > b.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+// release returns n previously reserved bytes to the budget.
+func (b *bufferBudget) release(n int64) {
+	atomic.AddInt64(&b.used, -n)
+}
+
+// available is how many bytes could currently be reserved.
+func (b *bufferBudget) available() int64 {
+	if n := b.limit - atomic.LoadInt64(&b.used); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// bufferBody reads a request body into memory so that it can be replayed for retries. If
+// the body doesn't fit in what is left of the buffer budget, fits is false and buf holds
+// whatever was read before that was discovered, which must be sent ahead of the rest of
+// the body. If it fits, its size stays reserved until it is released by the caller.
+func (t *Transport) bufferBody(body io.Reader, contentLength int64) (buf []byte, fits bool, err error) {
+	b := t.bufferBudget
+	if b == nil {
+		buf, err := readAll(body)
+		return buf, err == nil, err
+	}
+
+	if contentLength > 0 {
+		if !b.reserve(contentLength) {
+			return nil, false, nil
+		}
+		buf, err := readAll(body)
+		if err != nil {
+			b.release(contentLength)
+			return nil, false, err
+		}
+		// the reservation is corrected in case the body isn't the length it claims to be
+		b.release(contentLength - int64(len(buf)))
+		return buf, true, nil
+	}
+
+	// the size isn't known up front, so no more is read than could fit
+	available := b.available()
+	buf, err = readAll(io.LimitReader(body, available+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(buf)) > available || !b.reserve(int64(len(buf))) {
+		return buf, false, nil
+	}
+	return buf, true, nil
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.Copy(&buf, r)
+	return buf.Bytes(), err
+}
@@ -23,9 +23,11 @@ func (cr cancelReader) Close() error {
 	return cr.ReadCloser.Close()
 }
 
+// injectCancelReader delays cancel until the body of res is closed. If cancel is nil, as it
+// is for an attempt without a timeout, the body is left as is.
 func injectCancelReader(res *http.Response, cancel context.CancelFunc) *http.Response {
-	if res == nil {
-		return nil
+	if res == nil || cancel == nil {
+		return res
 	}
 
 	res.Body = cancelReader{
@@ -0,0 +1,266 @@
+package retryhttp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Config is a snapshot of the effective settings of a [Transport], after defaults have
+// been applied. It is returned by [Transport.Config] and is intended for debugging and
+// testing. Context overrides are not reflected, since they apply to individual requests.
+type Config struct {
+	// Transport is the internal roundtripper. See [WithTransport].
+	Transport http.RoundTripper
+
+	// MaxRetries is the maximum number of retries. See [WithMaxRetries].
+	MaxRetries int
+
+	// HardMaxRetries is the ceiling on retries that context overrides can't exceed, or -1
+	// if there is none. See [WithHardMaxRetries].
+	HardMaxRetries int
+
+	// ShouldRetryFn is the configured [ShouldRetryFn]. See [WithShouldRetryFn].
+	ShouldRetryFn ShouldRetryFn
+
+	// DelayFn is the configured [DelayFn]. It is nil if a [Backoff] is used instead. See
+	// [WithDelayFn].
+	DelayFn DelayFn
+
+	// Backoff reports whether a [Backoff] factory is configured. See [WithBackoff].
+	Backoff bool
+
+	// PreventRetryWithBody is whether retries are prevented for requests with bodies. See
+	// [WithPreventRetryWithBody].
+	PreventRetryWithBody bool
+
+	// AllowRetryWithGetBody is whether requests with GetBody may be retried despite
+	// PreventRetryWithBody. See [WithAllowRetryWithGetBody].
+	AllowRetryWithGetBody bool
+
+	// RefuseBodyBuffering is whether buffering request bodies into memory is refused. See
+	// [WithRefuseBodyBuffering].
+	RefuseBodyBuffering bool
+
+	// RecoverPanics is whether panics in the internal roundtripper are converted to errors.
+	// See [WithRecoverPanics].
+	RecoverPanics bool
+
+	// SingleFlight is whether concurrent identical requests share attempts. See
+	// [WithSingleFlight].
+	SingleFlight bool
+
+	// AttemptTimeout is the per-attempt timeout, or 0 if there is none. See
+	// [WithAttemptTimeout].
+	AttemptTimeout time.Duration
+
+	// SkipDoomedAttempts is whether retries that can't finish before the request's deadline
+	// are skipped. See [WithSkipDoomedAttempts].
+	SkipDoomedAttempts bool
+
+	// MinRoundTrip is the expected minimum round trip used to identify doomed retries. See
+	// [WithSkipDoomedAttempts].
+	MinRoundTrip time.Duration
+
+	// RetryAfterCap is the cap on Retry-After-derived delays, or 0 if there is none. See
+	// [WithRetryAfterCap].
+	RetryAfterCap time.Duration
+
+	// CancellationGrace is how long an attempt in flight may outlive the cancellation of its
+	// request's context, or 0 if it may not. See [WithCancellationGrace].
+	CancellationGrace time.Duration
+
+	// MaxRetryAfter is the longest Retry-After that is waited for, or 0 if there is no
+	// limit. See [WithMaxRetryAfter].
+	MaxRetryAfter time.Duration
+
+	// MaxInFlightRetries is the limit on concurrent retries, or 0 if there is none. See
+	// [WithMaxInFlightRetries].
+	MaxInFlightRetries int
+
+	// MaxAttemptsPerHost is the limit on concurrent attempts to each host, or 0 if there is
+	// none. See [WithMaxAttemptsPerHost].
+	MaxAttemptsPerHost int
+
+	// MinInterval is the minimum interval between attempts to the same host, or 0 if there
+	// is none. See [WithMinInterval].
+	MinInterval time.Duration
+
+	// HostBackoffReset is how long a host must go without failing for its backoff to start
+	// over, or 0 if backoff isn't tracked per host. See [WithHostBackoff].
+	HostBackoffReset time.Duration
+
+	// RetryBudget is the fraction of requests that may be retried, or 0 if retries are not
+	// budgeted. See [WithRetryBudget].
+	RetryBudget float64
+
+	// MaxBufferedBytes is the limit on request body bytes buffered at once, or 0 if there is
+	// none. See [WithMaxBufferedBytes].
+	MaxBufferedBytes int64
+
+	// MaxTotalDownload is the limit on response body bytes read across the attempts of a
+	// request before retries stop, or 0 if there is none. See [WithMaxTotalDownload].
+	MaxTotalDownload int64
+
+	// ConnectionReusePolicy is the policy for bodies of retried responses. See
+	// [WithConnectionReusePolicy].
+	ConnectionReusePolicy ConnectionReusePolicy
+
+	// AttemptHeader is the name of the attempt number header, or empty if none is sent.
+	// See [WithAttemptHeader].
+	AttemptHeader string
+
+	// ExposeAttemptHeader is the name of the response header holding the number of attempts,
+	// or empty if none is set. See [WithExposeAttemptHeader].
+	ExposeAttemptHeader string
+
+	// ResponseRetryHeader is the name of the response header that can lower the number of
+	// retries, or empty if none is read. See [WithResponseRetryHeader].
+	ResponseRetryHeader string
+
+	// DeadlineHeader is the name of the deadline header, or empty if none is read. See
+	// [WithDeadlineHeader].
+	DeadlineHeader string
+
+	// AutoIdempotencyKey is the name of the generated idempotency key header, or empty if
+	// none is generated. See [WithAutoIdempotencyKey].
+	AutoIdempotencyKey string
+
+	// AttemptCountContext is whether the attempt number is stored in each attempt's context.
+	// See [WithAttemptCountContext].
+	AttemptCountContext bool
+
+	// RetriesExhaustedError is whether an error is returned when retries are exhausted. See
+	// [WithRetriesExhaustedError].
+	RetriesExhaustedError bool
+
+	// Disabled is whether retry behavior is disabled. See [WithDisabled].
+	Disabled bool
+
+	// AssumeIdempotent is whether every request is assumed idempotent. See
+	// [WithAssumeIdempotent].
+	AssumeIdempotent bool
+}
+
+// Config returns a snapshot of the Transport's effective settings, including any defaults
+// that were applied for options that weren't provided.
+func (t *Transport) Config() Config {
+	t.initOnce.Do(t.init)
+
+	c := Config{
+		Transport:             t.rt,
+		MaxRetries:            *t.maxRetries,
+		HardMaxRetries:        -1,
+		ShouldRetryFn:         t.shouldRetryFn,
+		DelayFn:               t.delayFn,
+		Backoff:               t.newBackoff != nil,
+		PreventRetryWithBody:  t.preventRetryWithBody,
+		AllowRetryWithGetBody: t.allowRetryGetBody,
+		RefuseBodyBuffering:   t.refuseBuffering,
+		RecoverPanics:         t.recoverPanics,
+		SingleFlight:          t.flights != nil,
+		AttemptTimeout:        t.attemptTimeout,
+		SkipDoomedAttempts:    t.skipDoomed,
+		MinRoundTrip:          t.minRoundTrip,
+		RetryAfterCap:         t.retryAfterCap,
+		MaxRetryAfter:         t.maxRetryAfter,
+		CancellationGrace:     t.cancellationGrace,
+		MaxTotalDownload:      t.maxTotalDownload,
+		MaxInFlightRetries:    cap(t.retrySem),
+		ConnectionReusePolicy: t.connReusePolicy,
+		AttemptHeader:         t.attemptHeader,
+		ExposeAttemptHeader:   t.exposeAttemptHeader,
+		ResponseRetryHeader:   t.responseRetryHeader,
+		DeadlineHeader:        t.deadlineHeader,
+		AutoIdempotencyKey:    t.autoIdempotencyKey,
+		AttemptCountContext:   t.attemptCountContext,
+		RetriesExhaustedError: t.retriesExhaustedErr,
+		Disabled:              t.disabled,
+		AssumeIdempotent:      t.assumeIdempotent,
+	}
+	if t.hardMaxRetries != nil {
+		c.HardMaxRetries = *t.hardMaxRetries
+	}
+	if t.retryBudget != nil {
+		c.RetryBudget = t.retryBudget.fraction
+	}
+	if t.hostLimiter != nil {
+		c.MaxAttemptsPerHost = t.hostLimiter.limit
+	}
+	if t.hostSpacer != nil {
+		c.MinInterval = t.hostSpacer.interval
+	}
+	if t.hostBackoff != nil {
+		c.HostBackoffReset = t.hostBackoff.resetInterval
+	}
+	if t.bufferBudget != nil {
+		c.MaxBufferedBytes = t.bufferBudget.limit
+	}
+
+	return c
+}
+
+// validate reports the first setting that doesn't make sense, for [NewWithError].
+func (t *Transport) validate() error {
+	if t.maxRetries != nil && *t.maxRetries < 0 {
+		return fmt.Errorf("%w: max retries must not be negative, got %d", ErrInvalidConfig, *t.maxRetries)
+	}
+	if t.hardMaxRetries != nil && *t.hardMaxRetries < 0 {
+		return fmt.Errorf("%w: hard max retries must not be negative, got %d", ErrInvalidConfig, *t.hardMaxRetries)
+	}
+	for status, limit := range t.maxRetriesPerStatus {
+		if limit < 0 {
+			return fmt.Errorf("%w: max retries for status %d must not be negative, got %d", ErrInvalidConfig, status, limit)
+		}
+	}
+	if t.attemptTimeout < 0 {
+		return fmt.Errorf("%w: attempt timeout must not be negative, got %s", ErrInvalidConfig, t.attemptTimeout)
+	}
+	if t.retryAfterCap < 0 {
+		return fmt.Errorf("%w: retry-after cap must not be negative, got %s", ErrInvalidConfig, t.retryAfterCap)
+	}
+	if t.maxRetryAfter < 0 {
+		return fmt.Errorf("%w: max retry-after must not be negative, got %s", ErrInvalidConfig, t.maxRetryAfter)
+	}
+	if t.cancellationGrace < 0 {
+		return fmt.Errorf("%w: cancellation grace must not be negative, got %s", ErrInvalidConfig, t.cancellationGrace)
+	}
+	if t.maxTotalDownload < 0 {
+		return fmt.Errorf("%w: max total download must not be negative, got %d", ErrInvalidConfig, t.maxTotalDownload)
+	}
+	if t.onLongRetryAfter != nil && t.maxRetryAfter <= 0 {
+		return fmt.Errorf("%w: long retry-after callback is set but there is no limit set by WithMaxRetryAfter", ErrInvalidConfig)
+	}
+	if t.onThrottle != nil && t.retrySem == nil && t.retryBudget == nil {
+		return fmt.Errorf("%w: throttle callback is set but retries are not limited by WithMaxInFlightRetries or WithRetryBudget", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// clamp corrects negative counts and durations to zero, for [New].
+func (t *Transport) clamp() {
+	if t.maxRetries != nil && *t.maxRetries < 0 {
+		zero := 0
+		t.maxRetries = &zero
+	}
+	if t.hardMaxRetries != nil && *t.hardMaxRetries < 0 {
+		zero := 0
+		t.hardMaxRetries = &zero
+	}
+	if t.attemptTimeout < 0 {
+		t.attemptTimeout = 0
+	}
+	if t.retryAfterCap < 0 {
+		t.retryAfterCap = 0
+	}
+	if t.maxRetryAfter < 0 {
+		t.maxRetryAfter = 0
+	}
+	if t.cancellationGrace < 0 {
+		t.cancellationGrace = 0
+	}
+	if t.maxTotalDownload < 0 {
+		t.maxTotalDownload = 0
+	}
+}
@@ -0,0 +1,228 @@
+package retryhttp_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+func TestTransport_Config(t *testing.T) {
+	t.Run("should report defaults", func(t *testing.T) {
+		c := retryhttp.New().Config()
+
+		if c.Transport != http.DefaultTransport {
+			t.Errorf("unexpected transport: got %v, want http.DefaultTransport", c.Transport)
+		}
+		if c.MaxRetries != retryhttp.DefaultMaxRetries {
+			t.Errorf("unexpected max retries: got %d, want %d", c.MaxRetries, retryhttp.DefaultMaxRetries)
+		}
+		if c.HardMaxRetries != -1 {
+			t.Errorf("unexpected hard max retries: got %d, want %d", c.HardMaxRetries, -1)
+		}
+		if c.ShouldRetryFn == nil {
+			t.Error("expected default ShouldRetryFn to be set")
+		}
+		if c.DelayFn == nil || c.Backoff {
+			t.Error("expected default DelayFn to be set")
+		}
+		if c.AttemptTimeout != 0 || c.RetryAfterCap != 0 || c.MaxInFlightRetries != 0 || c.RetryBudget != 0 || c.MaxBufferedBytes != 0 {
+			t.Errorf("expected no limits by default, got %+v", c)
+		}
+		if c.PreventRetryWithBody || c.AllowRetryWithGetBody || c.Disabled || c.AssumeIdempotent {
+			t.Errorf("expected boolean settings to be false by default, got %+v", c)
+		}
+		if c.ConnectionReusePolicy != retryhttp.ConnectionReuseDrain {
+			t.Errorf("unexpected connection reuse policy: got %d, want %d", c.ConnectionReusePolicy, retryhttp.ConnectionReuseDrain)
+		}
+	})
+
+	t.Run("should report overrides", func(t *testing.T) {
+		rt := roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			return nil, nil
+		})
+		c := retryhttp.New(
+			retryhttp.WithTransport(rt),
+			retryhttp.WithMaxRetries(0),
+			retryhttp.WithHardMaxRetries(5),
+			retryhttp.WithBackoff(func() retryhttp.Backoff {
+				return &doublingBackoff{}
+			}),
+			retryhttp.WithPreventRetryWithBody(true),
+			retryhttp.WithAllowRetryWithGetBody(true),
+			retryhttp.WithAttemptTimeout(time.Second),
+			retryhttp.WithRetryAfterCap(time.Minute),
+			retryhttp.WithMaxInFlightRetries(7),
+			retryhttp.WithRetryBudget(0.2),
+			retryhttp.WithMaxBufferedBytes(1<<20),
+			retryhttp.WithConnectionReusePolicy(retryhttp.ConnectionReuseClose),
+			retryhttp.WithAttemptHeader("X-Retry-Attempt"),
+			retryhttp.WithDisabled(true),
+			retryhttp.WithAssumeIdempotent(true),
+		).Config()
+
+		if c.MaxRetries != 0 {
+			t.Errorf("unexpected max retries: got %d, want %d", c.MaxRetries, 0)
+		}
+		if c.HardMaxRetries != 5 {
+			t.Errorf("unexpected hard max retries: got %d, want %d", c.HardMaxRetries, 5)
+		}
+		if c.DelayFn != nil || !c.Backoff {
+			t.Error("expected a Backoff instead of a DelayFn")
+		}
+		if !c.PreventRetryWithBody || !c.AllowRetryWithGetBody || !c.Disabled || !c.AssumeIdempotent {
+			t.Errorf("expected boolean settings to be true, got %+v", c)
+		}
+		if c.AttemptTimeout != time.Second {
+			t.Errorf("unexpected attempt timeout: got %s, want %s", c.AttemptTimeout, time.Second)
+		}
+		if c.RetryAfterCap != time.Minute {
+			t.Errorf("unexpected retry-after cap: got %s, want %s", c.RetryAfterCap, time.Minute)
+		}
+		if c.MaxInFlightRetries != 7 {
+			t.Errorf("unexpected max in-flight retries: got %d, want %d", c.MaxInFlightRetries, 7)
+		}
+		if c.RetryBudget != 0.2 {
+			t.Errorf("unexpected retry budget: got %f, want %f", c.RetryBudget, 0.2)
+		}
+		if c.MaxBufferedBytes != 1<<20 {
+			t.Errorf("unexpected max buffered bytes: got %d, want %d", c.MaxBufferedBytes, 1<<20)
+		}
+		if c.ConnectionReusePolicy != retryhttp.ConnectionReuseClose {
+			t.Errorf("unexpected connection reuse policy: got %d, want %d", c.ConnectionReusePolicy, retryhttp.ConnectionReuseClose)
+		}
+		if c.AttemptHeader != "X-Retry-Attempt" {
+			t.Errorf("unexpected attempt header: got %s, want %s", c.AttemptHeader, "X-Retry-Attempt")
+		}
+	})
+}
+
+func TestNewWithError(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []func(*retryhttp.Transport)
+		wantErr bool
+	}{
+		{
+			name:    "should accept defaults",
+			options: nil,
+		},
+		{
+			name: "should accept a valid configuration",
+			options: []func(*retryhttp.Transport){
+				retryhttp.WithMaxRetries(0),
+				retryhttp.WithHardMaxRetries(5),
+				retryhttp.WithMaxRetriesPerStatus(map[int]int{http.StatusTooManyRequests: 5}),
+				retryhttp.WithAttemptTimeout(time.Second),
+				retryhttp.WithRetryAfterCap(time.Minute),
+				retryhttp.WithMaxInFlightRetries(3),
+				retryhttp.WithOnThrottle(func(_ retryhttp.Attempt) {}),
+				retryhttp.WithMaxRetryAfter(time.Hour),
+				retryhttp.WithCancellationGrace(time.Second),
+				retryhttp.WithMaxTotalDownload(1 << 20),
+				retryhttp.WithOnLongRetryAfter(func(_ retryhttp.Attempt, _ time.Duration) {}),
+			},
+		},
+		{
+			name:    "should reject negative max retries",
+			options: []func(*retryhttp.Transport){retryhttp.WithMaxRetries(-1)},
+			wantErr: true,
+		},
+		{
+			name:    "should reject negative hard max retries",
+			options: []func(*retryhttp.Transport){retryhttp.WithHardMaxRetries(-1)},
+			wantErr: true,
+		},
+		{
+			name: "should reject a negative per-status limit",
+			options: []func(*retryhttp.Transport){
+				retryhttp.WithMaxRetriesPerStatus(map[int]int{http.StatusServiceUnavailable: -2}),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "should reject a negative attempt timeout",
+			options: []func(*retryhttp.Transport){retryhttp.WithAttemptTimeout(-time.Second)},
+			wantErr: true,
+		},
+		{
+			name:    "should reject a negative retry-after cap",
+			options: []func(*retryhttp.Transport){retryhttp.WithRetryAfterCap(-time.Second)},
+			wantErr: true,
+		},
+		{
+			name:    "should reject a negative max retry-after",
+			options: []func(*retryhttp.Transport){retryhttp.WithMaxRetryAfter(-time.Second)},
+			wantErr: true,
+		},
+		{
+			name:    "should reject a negative cancellation grace",
+			options: []func(*retryhttp.Transport){retryhttp.WithCancellationGrace(-time.Second)},
+			wantErr: true,
+		},
+		{
+			name:    "should reject a negative max total download",
+			options: []func(*retryhttp.Transport){retryhttp.WithMaxTotalDownload(-1)},
+			wantErr: true,
+		},
+		{
+			name: "should reject a long retry-after callback without a limit",
+			options: []func(*retryhttp.Transport){
+				retryhttp.WithOnLongRetryAfter(func(_ retryhttp.Attempt, _ time.Duration) {}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should reject a throttle callback without a retry limit",
+			options: []func(*retryhttp.Transport){
+				retryhttp.WithOnThrottle(func(_ retryhttp.Attempt) {}),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := retryhttp.NewWithError(tt.options...)
+			if tt.wantErr {
+				if !errors.Is(err, retryhttp.ErrInvalidConfig) {
+					t.Fatalf("expected ErrInvalidConfig, got %v", err)
+				}
+				if tr != nil {
+					t.Fatal("expected nil transport")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected nil error but got %s", err)
+			}
+			if tr == nil {
+				t.Fatal("expected non-nil transport")
+			}
+		})
+	}
+}
+
+func TestNewClampsNegatives(t *testing.T) {
+	c := retryhttp.New(
+		retryhttp.WithMaxRetries(-1),
+		retryhttp.WithHardMaxRetries(-3),
+		retryhttp.WithAttemptTimeout(-time.Second),
+		retryhttp.WithRetryAfterCap(-time.Second),
+	).Config()
+
+	if c.MaxRetries != 0 {
+		t.Errorf("unexpected max retries: got %d, want %d", c.MaxRetries, 0)
+	}
+	if c.HardMaxRetries != 0 {
+		t.Errorf("unexpected hard max retries: got %d, want %d", c.HardMaxRetries, 0)
+	}
+	if c.AttemptTimeout != 0 {
+		t.Errorf("unexpected attempt timeout: got %s, want %s", c.AttemptTimeout, time.Duration(0))
+	}
+	if c.RetryAfterCap != 0 {
+		t.Errorf("unexpected retry-after cap: got %s, want %s", c.RetryAfterCap, time.Duration(0))
+	}
+}
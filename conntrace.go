@@ -0,0 +1,91 @@
+package retryhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ConnTrace describes how the connection for an attempt was obtained. It is reported by the
+// callback configured with [WithConnTraceRecorder].
+type ConnTrace struct {
+	// Reused is whether the attempt used a previously established connection.
+	Reused bool
+
+	// WasIdle is whether a reused connection was obtained from the idle pool.
+	WasIdle bool
+
+	// IdleTime is how long a reused connection was idle before the attempt, if WasIdle.
+	IdleTime time.Duration
+
+	// DNS is the time spent resolving the host, or 0 if no lookup was made.
+	DNS time.Duration
+
+	// Connect is the time spent dialing a new connection, or 0 if none was dialed.
+	Connect time.Duration
+
+	// TLSHandshake is the time spent on the TLS handshake of a new connection, or 0 if
+	// none was made.
+	TLSHandshake time.Duration
+}
+
+// connTracer collects a [ConnTrace] for a single attempt. Its callbacks may be called from
+// other goroutines, so it is safe for concurrent use.
+type connTracer struct {
+	mu    sync.Mutex
+	trace ConnTrace
+
+	dnsStart, connectStart, tlsStart time.Time
+}
+
+// withTrace returns a context that reports connection events to ct, in addition to any
+// trace already attached to ctx.
+func (ct *connTracer) withTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.trace.Reused = info.Reused
+			ct.trace.WasIdle = info.WasIdle
+			ct.trace.IdleTime = info.IdleTime
+		},
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.dnsStart = time.Now()
+		},
+		DNSDone: func(_ httptrace.DNSDoneInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.trace.DNS = time.Since(ct.dnsStart)
+		},
+		ConnectStart: func(_, _ string) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.connectStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.trace.Connect = time.Since(ct.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.trace.TLSHandshake = time.Since(ct.tlsStart)
+		},
+	})
+}
+
+func (ct *connTracer) result() ConnTrace {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.trace
+}
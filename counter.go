@@ -0,0 +1,105 @@
+package retryhttp
+
+import (
+	"sync"
+	"time"
+)
+
+// windowedCounter counts events over a sliding window made up of one-second buckets.
+// Events older than the window are forgotten, so the counter reflects recent behavior
+// only. It is safe for concurrent use.
+type windowedCounter struct {
+	mu      sync.Mutex
+	buckets []counterBucket
+}
+
+type counterBucket struct {
+	sec   int64 // unix second the bucket's count belongs to
+	count int64
+}
+
+func newWindowedCounter(window time.Duration) *windowedCounter {
+	n := int(window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+
+	return &windowedCounter{
+		buckets: make([]counterBucket, n),
+	}
+}
+
+func (c *windowedCounter) add(now time.Time, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addLocked(now, n)
+}
+
+func (c *windowedCounter) sum(now time.Time) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sumLocked(now)
+}
+
+func (c *windowedCounter) addLocked(now time.Time, n int64) {
+	sec := now.Unix()
+	b := &c.buckets[int(sec%int64(len(c.buckets)))]
+	if b.sec != sec {
+		b.sec = sec
+		b.count = 0
+	}
+	b.count += n
+}
+
+func (c *windowedCounter) sumLocked(now time.Time) int64 {
+	sec := now.Unix()
+	oldest := sec - int64(len(c.buckets))
+
+	var total int64
+	for _, b := range c.buckets {
+		if b.sec > oldest && b.sec <= sec {
+			total += b.count
+		}
+	}
+	return total
+}
+
+// retryBudgetWindow is the window over which a retry budget compares retries to requests.
+const retryBudgetWindow = time.Second * 10
+
+// retryBudget limits retries to a fraction of the requests made over a recent window.
+type retryBudget struct {
+	mu       sync.Mutex
+	fraction float64
+	requests *windowedCounter
+	retries  *windowedCounter
+}
+
+func newRetryBudget(fraction float64) *retryBudget {
+	return &retryBudget{
+		fraction: fraction,
+		requests: newWindowedCounter(retryBudgetWindow),
+		retries:  newWindowedCounter(retryBudgetWindow),
+	}
+}
+
+func (b *retryBudget) recordRequest() {
+	b.requests.add(time.Now(), 1)
+}
+
+// tryRetry records a retry and returns true if doing so keeps retries within budget.
+// Otherwise it returns false and records nothing.
+func (b *retryBudget) tryRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	retries := b.retries.sum(now)
+	requests := b.requests.sum(now)
+	if float64(retries+1) > b.fraction*float64(requests) {
+		return false
+	}
+
+	b.retries.add(now, 1)
+	return true
+}
@@ -0,0 +1,87 @@
+package retryhttp
+
+import (
+	"io"
+	"sync"
+)
+
+// countingReader counts the bytes read from a response body so that the size of each
+// attempt's response can be reported. The count is reported exactly once, when the body
+// is closed, if onClose is set.
+type countingReader struct {
+	io.ReadCloser
+
+	n       int64
+	once    sync.Once
+	onClose func(n int64)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) Close() error {
+	cr.once.Do(func() {
+		if cr.onClose != nil {
+			cr.onClose(cr.n)
+		}
+	})
+	return cr.ReadCloser.Close()
+}
+
+// replayReader records the first error from reading a request body that is being replayed
+// for a retry, so that the retry can be abandoned rather than sending a partial body.
+type replayReader struct {
+	io.ReadCloser
+
+	mu  sync.Mutex
+	err error
+}
+
+func (rr *replayReader) Read(p []byte) (int, error) {
+	n, err := rr.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		rr.mu.Lock()
+		if rr.err == nil {
+			rr.err = err
+		}
+		rr.mu.Unlock()
+	}
+	return n, err
+}
+
+func (rr *replayReader) readErr() error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return rr.err
+}
+
+// emptyCheckReader records whether a request body that is sent without being peeked turned
+// out to be empty, which is only known once an attempt has read it to the end.
+type emptyCheckReader struct {
+	io.ReadCloser
+
+	mu  sync.Mutex
+	n   int64
+	eof bool
+}
+
+func (er *emptyCheckReader) Read(p []byte) (int, error) {
+	n, err := er.ReadCloser.Read(p)
+	er.mu.Lock()
+	er.n += int64(n)
+	if err == io.EOF {
+		er.eof = true
+	}
+	er.mu.Unlock()
+	return n, err
+}
+
+// empty reports whether the body was read to the end without producing anything.
+func (er *emptyCheckReader) empty() bool {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return er.eof && er.n == 0
+}
@@ -1,31 +1,115 @@
 package retryhttp
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"math"
 	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// prng generates random numbers for calculating jitter
-var prng = rand.New(rand.NewSource(time.Now().UnixNano()))
+// prng generates random numbers for calculating jitter, unless a DelayFn is given its own
+var prng = &lockedRand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// lockedRand makes a *rand.Rand, which isn't safe for concurrent use, safe to share between
+// concurrent requests.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *lockedRand) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// defaultIdempotentMethods are the methods considered idempotent when no other set is
+// configured. https://www.rfc-editor.org/rfc/rfc9110.html#name-idempotent-methods
+var defaultIdempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
 
 // CustomizedShouldRetryFnOptions are used to tweak the behavior of CustomizedShouldRetryFn.
+// RetryTLSHandshakeErrors opts in to retrying idempotent requests that failed with a
+// transient TLS handshake error, as determined by [IsTLSHandshakeErr].
+// RetryConflictAndTooEarly opts in to retrying 409 Conflict and 425 Too Early responses
+// regardless of method, for write APIs (such as those using optimistic concurrency) that
+// use these statuses to signal that the request wasn't applied and a retry is appropriate.
+// RetryDialErrors opts in to retrying requests that failed to establish a connection, as
+// determined by [IsDialErr], regardless of method, since nothing was sent. Failures reading
+// or writing on an established connection are still only retried as usual.
 type CustomizedShouldRetryFnOptions struct {
-	IdempotentMethods    []string
-	RetryableStatusCodes []int
+	IdempotentMethods        []string
+	RetryableStatusCodes     []int
+	RetryTLSHandshakeErrors  bool
+	RetryConflictAndTooEarly bool
+	RetryDialErrors          bool
 }
 
 // CustomizedDelayFnOptions are used to tweak the behavior of [CustomizedDelayFn].
-// Base and Cap are used in calculating exponential backoff: min(base * (2 ** i), cap)
+// Base, Cap, and Multiplier are used in calculating exponential backoff:
+// min(base * (multiplier ** i), cap). Multiplier defaults to 2 if not set, and a multiplier
+// below 1 is treated as 1, so the delay stays at base rather than shrinking.
+// RateLimitBase and RateLimitCap are used instead of Base and Cap for 429 responses that
+// don't include a Retry-After header, since a rate-limited caller usually needs to back off
+// for longer than other failures warrant. They are only used if RateLimitBase is set;
+// RateLimitCap defaults to Cap if not set.
 // JitterMagnitude determines the maximum portion of delay specified by Retry-After to
 // add or subtract as jitter.
+// MaxJitter caps the absolute amount of that jitter, so that a large Retry-After (such as
+// an hour) isn't spread over many minutes. It is not capped if MaxJitter is not set.
+// JitterFn replaces that symmetric jitter if set: it is called with the delay specified by
+// Retry-After and returns the delay to use, for example only adding jitter so that a retry
+// is never made earlier than the server asked for. JitterMagnitude is ignored if it is set.
+// RetryAfterNoUndershoot makes the jitter applied to Retry-After delays only ever positive,
+// so a retry is never made before the server said it would be ready. A delay returned by
+// JitterFn is also raised to the Retry-After value if it falls short.
+// NoJitter disables all randomness: Retry-After delays are used exactly, and the backoff
+// is exactly min(base * (multiplier ** i), cap) rather than a random duration up to it.
+// UseRateLimitHeader opts in to reading the RateLimit header (for example
+// "limit=100, remaining=0, reset=30") when Retry-After is absent: if no requests remain,
+// the delay is the reset number of seconds, jittered like a Retry-After delay.
+// Rand is the source of randomness for jitter, for example one with a fixed seed so that
+// delays are reproducible in tests. It is used under a lock, so the DelayFn is safe for
+// concurrent requests, but it must not be used elsewhere at the same time. A shared source
+// seeded from the current time is used if it is not set.
 // [DefaultDelayFn] uses base=250ms, cap=10s, jitter magnitude=0.333
 type CustomizedDelayFnOptions struct {
-	Base            time.Duration
-	Cap             time.Duration
-	JitterMagnitude float64
+	Base                   time.Duration
+	Cap                    time.Duration
+	Multiplier             float64
+	JitterMagnitude        float64
+	RateLimitBase          time.Duration
+	RateLimitCap           time.Duration
+	MaxJitter              time.Duration
+	JitterFn               func(base time.Duration) time.Duration
+	RetryAfterNoUndershoot bool
+	NoJitter               bool
+	UseRateLimitHeader     bool
+	Rand                   *rand.Rand
 }
 
 // DefaultShouldRetryFn is a sane default starting point for a should retry policy.
@@ -34,14 +118,19 @@ type CustomizedDelayFnOptions struct {
 // Idempotency should also be taken into account when retrying: retrying a non-idempotent
 // request can result in creating duplicate resources for example.
 // DefaultShouldRetryFn's behavior is that:
+//   - Requests whose context was canceled are never retried.
 //   - DNS errors never reached the target server, and are therefore safe to retry.
+//   - If the server closed a reused keep-alive connection (see [IsIdleConnClosedErr]) and
+//     the request is guessed to be idempotent, it is retried. It is retried regardless of
+//     idempotency if net/http reports that none of the request was written.
 //   - If a timeout error occurred and the request is guessed to be idempotent, it is retried.
 //   - If a 429 status is returned or the Retry-After response header is included it is retried.
 //   - If the status code is retryable and the request is guessed to be idempotent it is retried.
 //
 // Default retryablestatus codes are [http.StatusBadGateway] and [http.StatusServiceUnavailable].
 // Idempotency is guessed based on the inclusion of the Idempotency-Key or X-Idempotency-Key
-// header, or an idempotent method (as defined in RFC 9110).
+// header, or an idempotent method (as defined in RFC 9110). Any request is considered
+// idempotent if [WithAssumeIdempotent] or [SetAssumeIdempotent] is used.
 var DefaultShouldRetryFn = CustomizedShouldRetryFn(CustomizedShouldRetryFnOptions{
 	// https://www.rfc-editor.org/rfc/rfc9110.html#name-idempotent-methods
 	IdempotentMethods: []string{
@@ -55,6 +144,54 @@ var DefaultShouldRetryFn = CustomizedShouldRetryFn(CustomizedShouldRetryFnOption
 	RetryableStatusCodes: []int{http.StatusBadGateway, http.StatusServiceUnavailable},
 })
 
+// AllServerErrorsShouldRetryFn behaves like [DefaultShouldRetryFn], except that every 5xx
+// status (500 through 599) is retryable for requests guessed to be idempotent, rather than
+// only 502 and 503. This is a more aggressive posture than the default, for destinations
+// whose server errors are known to be transient.
+var AllServerErrorsShouldRetryFn = CustomizedShouldRetryFn(CustomizedShouldRetryFnOptions{
+	IdempotentMethods: []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete,
+	},
+	RetryableStatusCodes: serverErrorStatusCodes(),
+})
+
+// serverErrorStatusCodes lists every 5xx status, including unassigned ones.
+func serverErrorStatusCodes() []int {
+	codes := make([]int, 0, 100)
+	for code := 500; code <= 599; code++ {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// safeMethods are the methods defined as safe (read-only) by RFC 9110.
+// https://www.rfc-editor.org/rfc/rfc9110.html#name-safe-methods
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// StrictSafeMethodsShouldRetryFn is a stricter alternative to [DefaultShouldRetryFn] for
+// systems where even idempotent writes are risky to repeat under partial failure. Only
+// requests using a safe method as defined by RFC 9110 (GET, HEAD, OPTIONS, and TRACE) are
+// ever retried, following the same logic as [DefaultShouldRetryFn]. Requests using any
+// other method, including PUT and DELETE, are never retried, even with an idempotency key
+// header or on a 429 status.
+var StrictSafeMethodsShouldRetryFn ShouldRetryFn = func(attempt Attempt) bool {
+	if !safeMethods[attempt.Req.Method] {
+		return false
+	}
+
+	return DefaultShouldRetryFn(attempt)
+}
+
 // CustomizedShouldRetryFn has the same logic as [DefaultShouldRetryFn] but it allows for
 // specifying which status codes should be assumed retryable and which methods should be
 // guessed idempotent. This is useful if the default behavior is desired, with small tweaks.
@@ -70,23 +207,217 @@ func CustomizedShouldRetryFn(options CustomizedShouldRetryFnOptions) func(attemp
 	}
 
 	return func(attempt Attempt) bool {
-		idempotent := guessIdempotent(attempt.Req, idempotentMethods)
+		idempotent := guessIdempotent(attempt, idempotentMethods)
 
 		if attempt.Err != nil {
+			// the caller explicitly aborted the request
+			if IsCanceledErr(attempt.Err) {
+				return false
+			}
+
 			// dns errors are safe to retry
 			if IsDNSErr(attempt.Err) {
+				attempt.ReportReason(RetryReasonDNSError)
+				return true
+			}
+
+			// neither was a request on a connection the server had already closed, if none of
+			// it was written. Otherwise its body may have been, so only idempotent requests
+			// are safe to retry.
+			if isNothingWrittenErr(attempt.Err) || (idempotent && IsIdleConnClosedErr(attempt.Err)) {
+				attempt.ReportReason(RetryReasonIdleConnClosed)
 				return true
 			}
 
-			return idempotent && IsTimeoutErr(attempt.Err)
+			// nor was a request that never got a connection, if the caller trusts that
+			if options.RetryDialErrors && IsDialErr(attempt.Err) {
+				attempt.ReportReason(RetryReasonDialError)
+				return true
+			}
+
+			if idempotent && IsTimeoutErr(attempt.Err) {
+				attempt.ReportReason(RetryReasonTimeout)
+				return true
+			}
+
+			if idempotent && options.RetryTLSHandshakeErrors && IsTLSHandshakeErr(attempt.Err) {
+				attempt.ReportReason(RetryReasonTLSHandshake)
+				return true
+			}
+
+			return false
 		}
 
 		// caller signalling they expect a retry
-		if attempt.Res.StatusCode == http.StatusTooManyRequests || attempt.Res.Header.Get("Retry-After") != "" {
+		if attempt.Res.StatusCode == http.StatusTooManyRequests {
+			attempt.ReportReason(StatusRetryReason(attempt.Res.StatusCode))
+			return true
+		}
+		if options.RetryConflictAndTooEarly && (attempt.Res.StatusCode == http.StatusConflict || attempt.Res.StatusCode == http.StatusTooEarly) {
+			attempt.ReportReason(StatusRetryReason(attempt.Res.StatusCode))
 			return true
 		}
+		if attempt.Res.Header.Get("Retry-After") != "" {
+			attempt.ReportReason(RetryReasonRetryAfter)
+			return true
+		}
+
+		if idempotent && retryableStatusCodes[attempt.Res.StatusCode] {
+			attempt.ReportReason(StatusRetryReason(attempt.Res.StatusCode))
+			return true
+		}
+
+		return false
+	}
+}
+
+// AdaptShouldRetryFn converts a [ShouldRetryFn] into a [ShouldRetryResultFn] that retries
+// whenever fn does, after the usual delay.
+func AdaptShouldRetryFn(fn ShouldRetryFn) ShouldRetryResultFn {
+	return func(attempt Attempt) ShouldRetryResult {
+		return ShouldRetryResult{Retry: fn(attempt)}
+	}
+}
+
+// StatusShouldRetryFn converts a predicate on response status codes into a [ShouldRetryFn].
+// The resulting function retries whenever statusFn returns true for the attempt's response
+// status. Attempts that failed with an error (and have no response) are never retried, so
+// compose it with another [ShouldRetryFn] if errors should be retried too.
+func StatusShouldRetryFn(statusFn func(status int) bool) ShouldRetryFn {
+	return func(attempt Attempt) bool {
+		if attempt.Err != nil || attempt.Res == nil {
+			return false
+		}
+
+		if statusFn(attempt.Res.StatusCode) {
+			attempt.ReportReason(StatusRetryReason(attempt.Res.StatusCode))
+			return true
+		}
+
+		return false
+	}
+}
+
+// RetryOnErrorSubstrings returns a [ShouldRetryFn] that retries attempts whose error
+// message contains any of subs, compared case-insensitively. It is intended for errors
+// from lower-level libraries that are only distinguishable by their message, such as
+// "i/o timeout", "broken pipe", or "server misbehaving". Matching on error strings is
+// fragile, since messages can change between versions, so prefer typed errors (see
+// [IsDNSErr] and [IsTimeoutErr]) when they are available. Attempts without an error are
+// never retried. Note that this does not take idempotency into account.
+func RetryOnErrorSubstrings(subs ...string) ShouldRetryFn {
+	lowered := make([]string, len(subs))
+	for i, sub := range subs {
+		lowered[i] = strings.ToLower(sub)
+	}
+
+	return func(attempt Attempt) bool {
+		if attempt.Err == nil {
+			return false
+		}
+
+		msg := strings.ToLower(attempt.Err.Error())
+		for _, sub := range lowered {
+			if strings.Contains(msg, sub) {
+				attempt.ReportReason(RetryReasonErrorSubstring)
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// NoRetryNearDeadline wraps a [ShouldRetryFn] so that no retry is made when the request
+// context's deadline is within margin of now. In that case a delay plus another attempt
+// can't plausibly complete in time, and the retry would just be canceled. If the deadline
+// is further away, or the context has no deadline, the decision is deferred to inner.
+func NoRetryNearDeadline(margin time.Duration, inner ShouldRetryFn) ShouldRetryFn {
+	return func(attempt Attempt) bool {
+		if deadline, ok := attempt.Req.Context().Deadline(); ok && time.Until(deadline) < margin {
+			return false
+		}
+
+		return inner(attempt)
+	}
+}
+
+// NoRetryOnHeader wraps a [ShouldRetryFn] so that no retry is made when the response
+// includes header with a true value (as parsed by [strconv.ParseBool]), for example
+// "X-No-Retry: true". This lets a server signal that a failure is permanent, even on a
+// status like 503 that is normally retried. Otherwise the decision is deferred to inner.
+func NoRetryOnHeader(header string, inner ShouldRetryFn) ShouldRetryFn {
+	return func(attempt Attempt) bool {
+		if attempt.Res != nil {
+			if noRetry, err := strconv.ParseBool(attempt.Res.Header.Get(header)); err == nil && noRetry {
+				return false
+			}
+		}
+
+		return inner(attempt)
+	}
+}
+
+// problemDetailsPeekSize is how much of a problem details body is inspected for its
+// retryable flag. Problem details are small, so the flag is expected well within it.
+const problemDetailsPeekSize = 16 * 1024
+
+// ProblemDetailsShouldRetryFn wraps a [ShouldRetryFn] to honor a "retryable" member in
+// [RFC 7807] problem details, which some APIs include in 5xx responses with the
+// application/problem+json content type to tell permanent failures from transient ones. If
+// it is false, no retry is made. If it is true, the request is retried if it is guessed to
+// be idempotent (see [Attempt.GuessIdempotent]), even for a status inner wouldn't retry.
+// Otherwise, or if the body can't be parsed, the decision is deferred to inner. Only the
+// first 16KiB of the body is inspected, and it is restored afterwards.
+//
+// [RFC 7807]: https://www.rfc-editor.org/rfc/rfc7807
+func ProblemDetailsShouldRetryFn(inner ShouldRetryFn) ShouldRetryFn {
+	return func(attempt Attempt) bool {
+		if attempt.Res == nil || attempt.Res.StatusCode < 500 ||
+			!strings.HasPrefix(attempt.Res.Header.Get("Content-Type"), "application/problem+json") {
+			return inner(attempt)
+		}
+
+		body, err := PeekResponseBody(attempt.Res, problemDetailsPeekSize)
+		if err != nil {
+			return inner(attempt)
+		}
+		var problem struct {
+			Retryable *bool `json:"retryable"`
+		}
+		if err := json.Unmarshal(body, &problem); err != nil || problem.Retryable == nil {
+			return inner(attempt)
+		}
+
+		if *problem.Retryable && attempt.GuessIdempotent() {
+			attempt.ReportReason(StatusRetryReason(attempt.Res.StatusCode))
+			return true
+		}
+		return false
+	}
+}
+
+// successSeenKey is the [Attempt.Values] key used by [NoRetryAfterSuccess].
+type successSeenKey struct{}
+
+// NoRetryAfterSuccess wraps a [ShouldRetryFn] so that no retry is made once any attempt of
+// a request has returned a 2xx response, even if inner would retry it (for example because
+// of a Retry-After header) or would retry a later attempt. This guards against repeating a
+// request around an ambiguous success, such as in long polling. Whether a success was seen
+// is tracked across attempts using [Attempt.Values].
+func NoRetryAfterSuccess(inner ShouldRetryFn) ShouldRetryFn {
+	return func(attempt Attempt) bool {
+		if attempt.Res != nil && attempt.Res.StatusCode >= 200 && attempt.Res.StatusCode < 300 {
+			if attempt.Values != nil {
+				attempt.Values[successSeenKey{}] = true
+			}
+			return false
+		}
+		if seen, _ := attempt.Values[successSeenKey{}].(bool); seen {
+			return false
+		}
 
-		return idempotent && retryableStatusCodes[attempt.Res.StatusCode]
+		return inner(attempt)
 	}
 }
 
@@ -108,64 +439,376 @@ var DefaultDelayFn = CustomizedDelayFn(CustomizedDelayFnOptions{
 })
 
 // CustomizedDelayFn has the same logic as [DefaultDelayFn] but it allows for specifying
-// the exponential backoff's base and maximum, as well as the fraction to calculate
-// jitter with.
+// the exponential backoff's base, maximum, and growth multiplier, as well as the fraction
+// to calculate jitter with.
 func CustomizedDelayFn(options CustomizedDelayFnOptions) func(attempt Attempt) time.Duration {
+	rng := prng
+	if options.Rand != nil {
+		rng = &lockedRand{r: options.Rand}
+	}
+
 	return func(attempt Attempt) time.Duration {
-		// check for a retry-after header
-		if attempt.Res != nil && attempt.Res.Header.Get("Retry-After") != "" {
-			retryAfterStr := attempt.Res.Header.Get("Retry-After")
-
-			// try parsing as an integer
-			// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After#delay-seconds
-			i, err := strconv.Atoi(retryAfterStr)
-			if err == nil {
-				return addJitter(time.Duration(i)*time.Second, options.JitterMagnitude)
+		// check for a retry-after header, or a rate limit header if there isn't one
+		if attempt.Res != nil {
+			d, ok := attempt.RetryAfter()
+			if !ok && options.UseRateLimitHeader {
+				d, ok = parseRateLimitReset(attempt.Res.Header.Get("RateLimit"))
+			}
+			if ok {
+				if options.NoJitter {
+					return d
+				}
+				if options.JitterFn != nil {
+					jittered := options.JitterFn(d)
+					if options.RetryAfterNoUndershoot && jittered < d {
+						return d
+					}
+					return jittered
+				}
+				return addJitter(rng, d, options.JitterMagnitude, options.MaxJitter, options.RetryAfterNoUndershoot)
 			}
+		}
 
-			// try parsing as date
-			// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After#http-date
-			t, err := time.Parse(http.TimeFormat, retryAfterStr)
-			if err == nil {
-				return addJitter(time.Until(t), options.JitterMagnitude)
+		// fall back to exponential backoff, from a larger base if rate limited
+		if options.RateLimitBase > 0 && attempt.Res != nil && attempt.Res.StatusCode == http.StatusTooManyRequests {
+			rateLimitCap := options.RateLimitCap
+			if rateLimitCap == 0 {
+				rateLimitCap = options.Cap
 			}
+			return expBackoff(rng, attempt.Count, options.RateLimitBase, rateLimitCap, options.Multiplier, !options.NoJitter)
+		}
+		return expBackoff(rng, attempt.Count, options.Base, options.Cap, options.Multiplier, !options.NoJitter)
+	}
+}
+
+// ClampDelayFn wraps a [DelayFn] so that its result is clamped into [min, max]. This
+// composes with delay policies that respect Retry-After, for example to cap a hostile
+// Retry-After value or to put a floor under backoff delays that jitter close to zero,
+// without reimplementing the policy. If min is greater than max, the bounds are swapped.
+func ClampDelayFn(inner DelayFn, min, max time.Duration) DelayFn {
+	if min > max {
+		min, max = max, min
+	}
+
+	return func(attempt Attempt) time.Duration {
+		delay := inner(attempt)
+		if delay < min {
+			return min
+		}
+		if delay > max {
+			return max
+		}
+
+		return delay
+	}
+}
+
+// ScheduleDelayFn returns a [DelayFn] that follows an explicit schedule, such as 1s, then
+// 5s, then 30s: the delay after the attempt numbered by [Attempt.Count] is
+// schedule[Count-1], and every attempt past the end of the schedule uses its last entry.
+// Plus or minus up to jitterMagnitude of each delay is added as jitter; a jitterMagnitude of
+// 0 uses the schedule exactly. Retry-After is not consulted. The delay is always 0 if the
+// schedule is empty.
+func ScheduleDelayFn(schedule []time.Duration, jitterMagnitude float64) DelayFn {
+	schedule = append([]time.Duration{}, schedule...)
+
+	return func(attempt Attempt) time.Duration {
+		if len(schedule) == 0 {
+			return 0
+		}
+
+		i := attempt.Count - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(schedule) {
+			i = len(schedule) - 1
+		}
+
+		if jitterMagnitude <= 0 {
+			return schedule[i]
 		}
+		return addJitter(prng, schedule[i], jitterMagnitude, 0, false)
+	}
+}
 
-		// fall back to exponential backoff
-		return expBackoff(attempt.Count, options.Base, options.Cap)
+// ReasonDelayFn returns a [DelayFn] that picks the delay strategy by the reason the
+// [ShouldRetryFn] reported for the retry, available as [Attempt.Reason]: for example an
+// immediate retry for [RetryReasonIdleConnClosed], Retry-After for 429s, and exponential
+// backoff for other statuses. Attempts whose reason isn't in strategies, including those
+// without a reported reason, are delayed by fallback.
+func ReasonDelayFn(strategies map[RetryReason]DelayFn, fallback DelayFn) DelayFn {
+	byReason := make(map[RetryReason]DelayFn, len(strategies))
+	for reason, delayFn := range strategies {
+		byReason[reason] = delayFn
+	}
+
+	return func(attempt Attempt) time.Duration {
+		if delayFn, ok := byReason[attempt.Reason]; ok {
+			return delayFn(attempt)
+		}
+		return fallback(attempt)
 	}
 }
 
+// headerDelayMax is the longest delay [HeaderDelayFn] will take from a header.
+const headerDelayMax = 5 * time.Minute
+
+// HeaderDelayFn returns a [DelayFn] that reads the delay from a response header holding a
+// whole number of milliseconds, such as a proprietary backpressure header used instead of
+// Retry-After. If there is no response, the header is absent, or its value isn't a
+// non-negative integer, the delay is decided by fallback instead. Delays from the header are
+// capped at 5 minutes, so a misbehaving server can't stall a request indefinitely.
+func HeaderDelayFn(headerName string, fallback DelayFn) DelayFn {
+	return func(attempt Attempt) time.Duration {
+		if attempt.Res == nil {
+			return fallback(attempt)
+		}
+
+		ms, err := strconv.ParseInt(strings.TrimSpace(attempt.Res.Header.Get(headerName)), 10, 64)
+		if err != nil || ms < 0 {
+			return fallback(attempt)
+		}
+		if ms > int64(headerDelayMax/time.Millisecond) {
+			return headerDelayMax
+		}
+
+		return time.Duration(ms) * time.Millisecond
+	}
+}
+
+// RateLimitBurstDelayFnOptions are used to tweak the behavior of [RateLimitBurstDelayFn].
+// Window is how far back 429 responses are counted, in whole seconds; it defaults to 30s.
+// Threshold is the number of 429 responses within the window that are tolerated before
+// delays are scaled up.
+// Multiplier is the factor delays grow by for each 429 response beyond the threshold; it
+// defaults to 2.
+// Cap is the longest scaled delay; it defaults to 1 minute. A delay already longer than Cap
+// is not shortened.
+type RateLimitBurstDelayFnOptions struct {
+	Window     time.Duration
+	Threshold  int
+	Multiplier float64
+	Cap        time.Duration
+}
+
+// RateLimitBurstDelayFn wraps a [DelayFn] so that its delays grow while the destination is
+// persistently rate limiting. Each 429 response it is asked to delay after is counted, across
+// every request using the returned DelayFn, and once the count within the recent window
+// exceeds the threshold, every delay returned by inner is multiplied by
+// multiplier ** (count - threshold), up to the cap. This backs a client off from sustained
+// throttling, which the per-request attempt count alone doesn't reflect. The returned
+// DelayFn should be shared by the requests to one destination.
+func RateLimitBurstDelayFn(inner DelayFn, options RateLimitBurstDelayFnOptions) DelayFn {
+	window := options.Window
+	if window <= 0 {
+		window = time.Second * 30
+	}
+	multiplier := options.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	maxDelay := options.Cap
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+	rateLimited := newWindowedCounter(window)
+
+	return func(attempt Attempt) time.Duration {
+		now := time.Now()
+		if attempt.Res != nil && attempt.Res.StatusCode == http.StatusTooManyRequests {
+			rateLimited.add(now, 1)
+		}
+
+		delay := inner(attempt)
+		excess := rateLimited.sum(now) - int64(options.Threshold)
+		if excess <= 0 || delay >= maxDelay {
+			return delay
+		}
+
+		scaled := float64(delay) * math.Pow(multiplier, float64(excess))
+		if scaled >= float64(maxDelay) {
+			return maxDelay
+		}
+		return time.Duration(scaled)
+	}
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of the attempt's
+// response, if it has one that can be parsed. The parser configured with
+// [WithRetryAfterParser] is tried first, then the standard formats: an integer number of
+// seconds or an HTTP date. This is useful for a custom [DelayFn] that respects Retry-After.
+func (a Attempt) RetryAfter() (time.Duration, bool) {
+	if a.Res == nil {
+		return 0, false
+	}
+
+	return parseRetryAfterWith(a.retryAfterParser, a.Res.Header.Get("Retry-After"))
+}
+
+// parseRetryAfterWith parses the value of a Retry-After header using parser, falling back to
+// [parseRetryAfter] if parser is nil or can't parse it.
+func parseRetryAfterWith(parser func(value string) (time.Duration, bool), retryAfterStr string) (time.Duration, bool) {
+	if parser != nil && retryAfterStr != "" {
+		if d, ok := parser(retryAfterStr); ok {
+			return d, true
+		}
+	}
+
+	return parseRetryAfter(retryAfterStr)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which can either be an integer
+// number of seconds or an HTTP date.
+func parseRetryAfter(retryAfterStr string) (time.Duration, bool) {
+	if retryAfterStr == "" {
+		return 0, false
+	}
+
+	// try parsing as an integer
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After#delay-seconds
+	i, err := strconv.Atoi(retryAfterStr)
+	if err == nil {
+		return time.Duration(i) * time.Second, true
+	}
+
+	// try parsing as date
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After#http-date
+	t, err := time.Parse(http.TimeFormat, retryAfterStr)
+	if err == nil {
+		return time.Until(t), true
+	}
+
+	return 0, false
+}
+
+// parseRateLimitReset parses the value of a RateLimit header, a list of limit, remaining,
+// and reset fields such as "limit=100, remaining=0, reset=30". It only reports a delay if
+// remaining is 0, in which case it is the reset number of seconds.
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+func parseRateLimitReset(rateLimitStr string) (time.Duration, bool) {
+	if rateLimitStr == "" {
+		return 0, false
+	}
+
+	remaining, reset := -1, -1
+	for _, field := range strings.Split(rateLimitStr, ",") {
+		// parameters of a field, such as a policy name, don't matter here
+		if i := strings.IndexByte(field, ';'); i >= 0 {
+			field = field[:i]
+		}
+		i := strings.IndexByte(field, '=')
+		if i < 0 {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(field[i+1:]))
+		if err != nil || v < 0 {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(field[:i])) {
+		case "remaining":
+			remaining = v
+		case "reset":
+			reset = v
+		}
+	}
+	if remaining != 0 || reset < 0 {
+		return 0, false
+	}
+
+	return time.Duration(reset) * time.Second, true
+}
+
 // based on "full jitter": https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
-func expBackoff(attempt int, base time.Duration, cap time.Duration) time.Duration {
-	exp := math.Pow(2, float64(attempt-1))
+func expBackoff(rng *lockedRand, attempt int, base time.Duration, cap time.Duration, multiplier float64, jitter bool) time.Duration {
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	// a multiplier below 1 would shrink or flip the sign of the delay
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	exp := math.Pow(multiplier, float64(attempt-1))
 	v := float64(base) * exp
-	return time.Duration(
-		prng.Int63n(int64(math.Min(float64(cap), v))),
-	)
+	if !jitter {
+		return time.Duration(math.Min(float64(cap), v))
+	}
+
+	upper := int64(math.Min(float64(cap), v))
+	if upper <= 0 {
+		return 0
+	}
+	return time.Duration(rng.Int63n(upper))
 }
 
 // default jitter is plus or minus 1/3 of the duration
-func addJitter(d time.Duration, magnitude float64) time.Duration {
+func addJitter(rng *lockedRand, d time.Duration, magnitude float64, maxJitter time.Duration, positiveOnly bool) time.Duration {
 	f := float64(d)
 	mj := f * magnitude
+	if maxJitter > 0 && mj > float64(maxJitter) {
+		mj = float64(maxJitter)
+	}
 
 	// randomness determines jitter magnitude
-	j := prng.Float64() * mj
+	j := rng.Float64() * mj
 
 	// randomness determines if jitter is added or subtracted
-	coin := prng.Float64()
-	if coin < 0.5 {
+	coin := rng.Float64()
+	if positiveOnly || coin < 0.5 {
 		return time.Duration(f + j)
 	}
 
 	return time.Duration(f - j)
 }
 
-func guessIdempotent(req *http.Request, idempotentMethods map[string]bool) bool {
+func guessIdempotent(attempt Attempt, idempotentMethods map[string]bool) bool {
+	if attempt.assumeIdempotent {
+		return true
+	}
+
+	req := attempt.Req
 	if req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != "" {
 		return true
 	}
 
 	return idempotentMethods[req.Method]
 }
+
+// GuessIdempotent reports whether the attempt's request is guessed to be idempotent, the
+// same way [DefaultShouldRetryFn] guesses it: the request uses an idempotent method as
+// defined in RFC 9110, includes the Idempotency-Key or X-Idempotency-Key header, or is
+// assumed idempotent using [WithAssumeIdempotent] or [SetAssumeIdempotent]. This is useful
+// for a custom [ShouldRetryFn] that needs to take idempotency into account.
+func (a Attempt) GuessIdempotent() bool {
+	return guessIdempotent(a, defaultIdempotentMethods)
+}
+
+// PeekResponseBody reads up to n bytes from the start of a response body and returns them,
+// restoring the body so that it can still be read from the beginning afterwards. This is
+// useful for a [ShouldRetryFn] or [DelayFn] that needs to inspect the body without
+// consuming it. Only the first n bytes are held in memory; the rest of the body is
+// streamed as normal. A body already buffered by [WithBufferResponseOn] is not read at all.
+func PeekResponseBody(res *http.Response, n int64) ([]byte, error) {
+	if res == nil || res.Body == nil || res.Body == http.NoBody {
+		return nil, nil
+	}
+	if b, ok := res.Body.(*bufferedBody); ok {
+		if int64(len(b.data)) > n {
+			return b.data[:n], nil
+		}
+		return b.data, nil
+	}
+
+	peeked, err := io.ReadAll(io.LimitReader(res.Body, n))
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(peeked), res.Body),
+		Closer: res.Body,
+	}
+
+	return peeked, err
+}
@@ -1,8 +1,19 @@
 package retryhttp_test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -81,6 +92,44 @@ func TestDefaultShouldRetryFn(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "should not retry a canceled request",
+			attempt: retryhttp.Attempt{
+				Count: 1,
+				Req: &http.Request{
+					Method: http.MethodGet,
+				},
+				Err: context.Canceled,
+			},
+			want: false,
+		},
+		{
+			name: "should not retry a canceled request when the cancellation is wrapped",
+			attempt: retryhttp.Attempt{
+				Count: 1,
+				Req: &http.Request{
+					Method: http.MethodGet,
+				},
+				Err: &net.OpError{
+					Op:  "dial",
+					Err: fmt.Errorf("connecting: %w", context.Canceled),
+				},
+			},
+			want: false,
+		},
+		{
+			name: "should not retry a canceled request that also looks like a timeout",
+			attempt: retryhttp.Attempt{
+				Count: 1,
+				Req: &http.Request{
+					Method: http.MethodGet,
+				},
+				Err: &net.OpError{
+					Err: canceledTimeoutErr{},
+				},
+			},
+			want: false,
+		},
 		{
 			name: "should retry on 429 status",
 			attempt: retryhttp.Attempt{
@@ -185,6 +234,449 @@ func TestDefaultShouldRetryFn(t *testing.T) {
 	}
 }
 
+// canceledTimeoutErr is a timeout error caused by cancellation.
+type canceledTimeoutErr struct{}
+
+func (e canceledTimeoutErr) Error() string { return "canceled timeout" }
+func (e canceledTimeoutErr) Timeout() bool { return true }
+func (e canceledTimeoutErr) Unwrap() error { return context.Canceled }
+
+func TestCustomizedShouldRetryFnTLSHandshake(t *testing.T) {
+	handshakeErr := &url.Error{
+		Op:  "Get",
+		URL: "https://example.com",
+		Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"},
+	}
+	validationErr := &url.Error{
+		Op:  "Get",
+		URL: "https://example.com",
+		Err: x509.CertificateInvalidError{Reason: x509.Expired},
+	}
+
+	tests := []struct {
+		name       string
+		optIn      bool
+		method     string
+		err        error
+		want       bool
+		wantReason retryhttp.RetryReason
+	}{
+		{
+			name:       "should retry a transient handshake error when opted in",
+			optIn:      true,
+			method:     http.MethodGet,
+			err:        handshakeErr,
+			want:       true,
+			wantReason: retryhttp.RetryReasonTLSHandshake,
+		},
+		{
+			name:   "should not retry a transient handshake error by default",
+			method: http.MethodGet,
+			err:    handshakeErr,
+			want:   false,
+		},
+		{
+			name:   "should not retry a transient handshake error for a non-idempotent request",
+			optIn:  true,
+			method: http.MethodPost,
+			err:    handshakeErr,
+			want:   false,
+		},
+		{
+			name:   "should not retry a certificate validation error",
+			optIn:  true,
+			method: http.MethodGet,
+			err:    validationErr,
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reason retryhttp.RetryReason
+			shouldRetry := retryhttp.CustomizedShouldRetryFn(retryhttp.CustomizedShouldRetryFnOptions{
+				IdempotentMethods:       []string{http.MethodGet},
+				RetryTLSHandshakeErrors: tt.optIn,
+			})
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithMaxRetries(1),
+				retryhttp.WithShouldRetryFn(shouldRetry),
+				retryhttp.WithDelayRecorder(func(attempt retryhttp.Attempt, _ time.Duration) {
+					reason = attempt.Reason
+				}),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					return nil, tt.err
+				})),
+			)
+
+			req, err := http.NewRequest(tt.method, "https://example.com", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			_, _ = tr.RoundTrip(req)
+
+			if got := reason != ""; got != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", got, tt.want)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("unexpected reason: got %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCustomizedShouldRetryFnDialErrors(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	writeErr := &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken pipe")}
+
+	tests := []struct {
+		name       string
+		optIn      bool
+		err        error
+		want       bool
+		wantReason retryhttp.RetryReason
+	}{
+		{
+			name:       "should retry a non-idempotent request that failed to dial when opted in",
+			optIn:      true,
+			err:        dialErr,
+			want:       true,
+			wantReason: retryhttp.RetryReasonDialError,
+		},
+		{
+			name: "should not retry a non-idempotent request that failed to dial by default",
+			err:  dialErr,
+			want: false,
+		},
+		{
+			name:  "should not retry a non-idempotent request that failed to read",
+			optIn: true,
+			err:   readErr,
+			want:  false,
+		},
+		{
+			name:  "should not retry a non-idempotent request that failed to write",
+			optIn: true,
+			err:   writeErr,
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reason retryhttp.RetryReason
+			shouldRetry := retryhttp.CustomizedShouldRetryFn(retryhttp.CustomizedShouldRetryFnOptions{
+				IdempotentMethods: []string{http.MethodGet},
+				RetryDialErrors:   tt.optIn,
+			})
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithMaxRetries(1),
+				retryhttp.WithShouldRetryFn(shouldRetry),
+				retryhttp.WithDelayRecorder(func(attempt retryhttp.Attempt, _ time.Duration) {
+					reason = attempt.Reason
+				}),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					return nil, tt.err
+				})),
+			)
+
+			req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			_, _ = tr.RoundTrip(req)
+
+			if got := reason != ""; got != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", got, tt.want)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("unexpected reason: got %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestStrictSafeMethodsShouldRetryFn(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		status int
+		want   bool
+	}{
+		{
+			name:   "should retry a GET on a retryable status",
+			method: http.MethodGet,
+			status: http.StatusServiceUnavailable,
+			want:   true,
+		},
+		{
+			name:   "should retry a HEAD on a 429",
+			method: http.MethodHead,
+			status: http.StatusTooManyRequests,
+			want:   true,
+		},
+		{
+			name:   "should not retry a GET on a non-retryable status",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			want:   false,
+		},
+		{
+			name:   "should not retry a PUT",
+			method: http.MethodPut,
+			status: http.StatusServiceUnavailable,
+			want:   false,
+		},
+		{
+			name:   "should not retry a DELETE",
+			method: http.MethodDelete,
+			status: http.StatusServiceUnavailable,
+			want:   false,
+		},
+		{
+			name:   "should not retry a POST with an idempotency key",
+			method: http.MethodPost,
+			header: http.Header{"Idempotency-Key": []string{"foobar"}},
+			status: http.StatusServiceUnavailable,
+			want:   false,
+		},
+		{
+			name:   "should not retry a POST on a 429",
+			method: http.MethodPost,
+			status: http.StatusTooManyRequests,
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := retryhttp.StrictSafeMethodsShouldRetryFn(retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: tt.method, Header: tt.header},
+				Res:   &http.Response{StatusCode: tt.status},
+			})
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusShouldRetryFn(t *testing.T) {
+	shouldRetry := retryhttp.StatusShouldRetryFn(func(status int) bool {
+		return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+	})
+
+	tests := []struct {
+		name    string
+		attempt retryhttp.Attempt
+		want    bool
+	}{
+		{
+			name: "should retry a configured status",
+			attempt: retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: http.MethodPost},
+				Res:   &http.Response{StatusCode: http.StatusServiceUnavailable},
+			},
+			want: true,
+		},
+		{
+			name: "should not retry a status that is not configured",
+			attempt: retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: http.MethodGet},
+				Res:   &http.Response{StatusCode: http.StatusBadGateway},
+			},
+			want: false,
+		},
+		{
+			name: "should not retry errors",
+			attempt: retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: http.MethodGet},
+				Err:   &net.DNSError{IsNotFound: true},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := shouldRetry(tt.attempt)
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryOnErrorSubstrings(t *testing.T) {
+	shouldRetry := retryhttp.RetryOnErrorSubstrings("i/o timeout", "Broken Pipe", "server misbehaving")
+
+	tests := []struct {
+		name    string
+		attempt retryhttp.Attempt
+		want    bool
+	}{
+		{
+			name: "should retry an i/o timeout",
+			attempt: retryhttp.Attempt{
+				Req: &http.Request{Method: http.MethodPost},
+				Err: errors.New("read tcp 10.0.0.1:1234->10.0.0.2:443: i/o timeout"),
+			},
+			want: true,
+		},
+		{
+			name: "should match case-insensitively",
+			attempt: retryhttp.Attempt{
+				Req: &http.Request{Method: http.MethodPost},
+				Err: errors.New("write: BROKEN PIPE"),
+			},
+			want: true,
+		},
+		{
+			name: "should match wrapped errors",
+			attempt: retryhttp.Attempt{
+				Req: &http.Request{Method: http.MethodGet},
+				Err: fmt.Errorf("driver: %w", errors.New("lookup db.internal: server misbehaving")),
+			},
+			want: true,
+		},
+		{
+			name: "should not retry other errors",
+			attempt: retryhttp.Attempt{
+				Req: &http.Request{Method: http.MethodGet},
+				Err: errors.New("connection refused"),
+			},
+			want: false,
+		},
+		{
+			name: "should not retry responses without errors",
+			attempt: retryhttp.Attempt{
+				Req: &http.Request{Method: http.MethodGet},
+				Res: &http.Response{StatusCode: http.StatusGatewayTimeout, Status: "504 i/o timeout"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := shouldRetry(tt.attempt)
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoRetryNearDeadline(t *testing.T) {
+	alwaysRetry := func(_ retryhttp.Attempt) bool {
+		return true
+	}
+	shouldRetry := retryhttp.NoRetryNearDeadline(time.Second, alwaysRetry)
+
+	tests := []struct {
+		name     string
+		deadline time.Duration // 0 means no deadline
+		want     bool
+	}{
+		{
+			name:     "should not retry when the deadline is within the margin",
+			deadline: time.Millisecond * 100,
+			want:     false,
+		},
+		{
+			name:     "should defer to inner when the deadline is far away",
+			deadline: time.Minute,
+			want:     true,
+		},
+		{
+			name: "should defer to inner when there is no deadline",
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.deadline != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+
+			actual := shouldRetry(retryhttp.Attempt{
+				Count: 1,
+				Req:   req,
+				Res:   &http.Response{StatusCode: http.StatusServiceUnavailable},
+			})
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoRetryOnHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       http.Header
+		wantAttempts int
+	}{
+		{
+			name:         "should not retry a 503 with the header set to true",
+			header:       http.Header{"X-No-Retry": []string{"true"}},
+			wantAttempts: 1,
+		},
+		{
+			name:         "should retry a 503 without the header",
+			header:       http.Header{},
+			wantAttempts: 4,
+		},
+		{
+			name:         "should retry a 503 with the header set to false",
+			header:       http.Header{"X-No-Retry": []string{"false"}},
+			wantAttempts: 4,
+		},
+		{
+			name:         "should retry a 503 with an unparseable header",
+			header:       http.Header{"X-No-Retry": []string{"maybe"}},
+			wantAttempts: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attemptCount := 0
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithShouldRetryFn(retryhttp.NoRetryOnHeader("X-No-Retry", retryhttp.DefaultShouldRetryFn)),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					attemptCount++
+					return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: tt.header, Body: http.NoBody}, nil
+				})),
+			)
+
+			req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected nil error but got %s", err)
+			}
+			res.Body.Close()
+
+			if attemptCount != tt.wantAttempts {
+				t.Fatalf("attempt count does not match expected; got %d, want %d", attemptCount, tt.wantAttempts)
+			}
+		})
+	}
+}
+
 func TestDefaultDelayFn(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -320,3 +812,1102 @@ func TestDefaultDelayFn(t *testing.T) {
 		})
 	}
 }
+
+func TestPeekResponseBody(t *testing.T) {
+	res := &http.Response{
+		Body: io.NopCloser(strings.NewReader(`{"code":"Throttled","message":"slow down"}`)),
+	}
+
+	peeked, err := retryhttp.PeekResponseBody(res, 19)
+	if err != nil {
+		t.Fatalf("unexpected error peeking body: %s", err)
+	}
+	if string(peeked) != `{"code":"Throttled"` {
+		t.Fatalf("unexpected peeked bytes: got %s", string(peeked))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading body: %s", err)
+	}
+	if string(body) != `{"code":"Throttled","message":"slow down"}` {
+		t.Fatalf("body was not restored: got %s", string(body))
+	}
+
+	if peeked, err := retryhttp.PeekResponseBody(&http.Response{Body: http.NoBody}, 10); peeked != nil || err != nil {
+		t.Fatalf("expected nothing peeked from an empty body, got %q, %v", peeked, err)
+	}
+}
+
+func TestCustomizedDelayFnMultiplier(t *testing.T) {
+	tests := []struct {
+		name       string
+		multiplier float64
+		wantHigh   []time.Duration // upper bound of the delay for attempts 1, 2, 3...
+	}{
+		{
+			name:     "should default to doubling",
+			wantHigh: []time.Duration{100, 200, 400, 800, 1000, 1000},
+		},
+		{
+			name:       "should grow gently with a 1.5 multiplier",
+			multiplier: 1.5,
+			wantHigh:   []time.Duration{100, 150, 225, 338, 507, 760, 1000},
+		},
+		{
+			name:       "should grow steeply with a 3 multiplier",
+			multiplier: 3,
+			wantHigh:   []time.Duration{100, 300, 900, 1000, 1000},
+		},
+		{
+			name:       "should not shrink with a multiplier below 1",
+			multiplier: 0.5,
+			wantHigh:   []time.Duration{100, 100, 100, 100, 100},
+		},
+		{
+			name:       "should not go negative with a negative multiplier",
+			multiplier: -2,
+			wantHigh:   []time.Duration{100, 100, 100, 100, 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+				Base:       time.Millisecond * 100,
+				Cap:        time.Second,
+				Multiplier: tt.multiplier,
+			})
+
+			for i, high := range tt.wantHigh {
+				high *= time.Millisecond
+				var max time.Duration
+				for j := 0; j < 1000; j++ {
+					actual := delayFn(retryhttp.Attempt{Count: i + 1, Res: &http.Response{Header: http.Header{}}})
+					if actual < 0 || actual > high {
+						t.Fatalf("attempt %d: delay out of range: got %s, want between 0 and %s", i+1, actual, high)
+					}
+					if actual > max {
+						max = actual
+					}
+				}
+
+				// full jitter is uniform, so over many samples the bound is approached
+				if max < high*3/4 {
+					t.Errorf("attempt %d: delays never approached the bound: max %s, want close to %s", i+1, max, high)
+				}
+			}
+		})
+	}
+	// a shrinking delay would eventually be less than a nanosecond, which can't be jittered
+	for _, multiplier := range []float64{0.5, -2} {
+		delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+			Base:       time.Millisecond * 100,
+			Cap:        time.Second,
+			Multiplier: multiplier,
+		})
+		if actual := delayFn(retryhttp.Attempt{Count: 100, Res: &http.Response{Header: http.Header{}}}); actual < 0 || actual > time.Millisecond*100 {
+			t.Errorf("multiplier %v: late attempt delay out of range: got %s", multiplier, actual)
+		}
+	}
+
+	// nor can a zero base
+	delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{Cap: time.Second})
+	if actual := delayFn(retryhttp.Attempt{Count: 1, Res: &http.Response{Header: http.Header{}}}); actual != 0 {
+		t.Errorf("expected no delay from a zero base but got %s", actual)
+	}
+}
+
+func TestCustomizedDelayFnRateLimit(t *testing.T) {
+	delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+		Base:            time.Millisecond * 100,
+		Cap:             time.Second,
+		JitterMagnitude: 0.333,
+		RateLimitBase:   time.Second * 5,
+		RateLimitCap:    time.Minute,
+	})
+
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		attempt  int
+		wantLow  time.Duration
+		wantHigh time.Duration
+	}{
+		{
+			name:     "should use the rate limit backoff for a 429 without retry-after",
+			status:   http.StatusTooManyRequests,
+			attempt:  2,
+			wantLow:  0,
+			wantHigh: time.Second * 10,
+		},
+		{
+			name:     "should cap the rate limit backoff",
+			status:   http.StatusTooManyRequests,
+			attempt:  10,
+			wantLow:  0,
+			wantHigh: time.Minute,
+		},
+		{
+			name:     "should respect retry-after for a 429 that includes it",
+			status:   http.StatusTooManyRequests,
+			header:   http.Header{"Retry-After": []string{"1"}},
+			attempt:  2,
+			wantLow:  time.Millisecond * 666,
+			wantHigh: time.Millisecond * 1333,
+		},
+		{
+			name:     "should use the regular backoff for other statuses",
+			status:   http.StatusServiceUnavailable,
+			attempt:  2,
+			wantLow:  0,
+			wantHigh: time.Millisecond * 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+
+			var max time.Duration
+			for i := 0; i < 1000; i++ {
+				actual := delayFn(retryhttp.Attempt{
+					Count: tt.attempt,
+					Res:   &http.Response{StatusCode: tt.status, Header: header},
+				})
+				if actual < tt.wantLow || actual > tt.wantHigh {
+					t.Fatalf("delay out of range: got %s, want between %s and %s", actual, tt.wantLow, tt.wantHigh)
+				}
+				if actual > max {
+					max = actual
+				}
+			}
+			if max < tt.wantHigh/2 {
+				t.Errorf("delays never approached the upper bound: max %s, want close to %s", max, tt.wantHigh)
+			}
+		})
+	}
+}
+
+func TestCustomizedDelayFnJitterFn(t *testing.T) {
+	// only ever add jitter, so a retry is never made earlier than requested
+	positiveJitter := func(base time.Duration) time.Duration {
+		return base + time.Duration(rand.Int63n(int64(base/10)+1))
+	}
+
+	delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+		Base:            time.Millisecond * 100,
+		Cap:             time.Second,
+		JitterMagnitude: 0.333, // ignored since JitterFn is set
+		JitterFn:        positiveJitter,
+	})
+
+	res := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+	var max time.Duration
+	for i := 0; i < 1000; i++ {
+		actual := delayFn(retryhttp.Attempt{Count: 1, Res: res})
+		if actual < time.Second*2 {
+			t.Fatalf("delay undershot the base: got %s, want at least %s", actual, time.Second*2)
+		}
+		if actual > time.Millisecond*2200 {
+			t.Fatalf("delay out of range: got %s, want at most %s", actual, time.Millisecond*2200)
+		}
+		if actual > max {
+			max = actual
+		}
+	}
+	if max == time.Second*2 {
+		t.Error("expected the jitter function to add jitter")
+	}
+
+	// the jitter function only applies to Retry-After delays
+	actual := delayFn(retryhttp.Attempt{Count: 1, Res: &http.Response{Header: http.Header{}}})
+	if actual < 0 || actual > time.Millisecond*100 {
+		t.Fatalf("delay out of range: got %s, want between 0 and %s", actual, time.Millisecond*100)
+	}
+}
+
+func TestCustomizedDelayFnRetryAfterNoUndershoot(t *testing.T) {
+	res := &http.Response{Header: http.Header{"Retry-After": []string{"5"}}}
+
+	t.Run("should never undershoot retry-after with the flag on", func(t *testing.T) {
+		delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+			Base:                   time.Millisecond * 100,
+			Cap:                    time.Second,
+			JitterMagnitude:        0.333,
+			RetryAfterNoUndershoot: true,
+		})
+
+		for i := 0; i < 1000; i++ {
+			actual := delayFn(retryhttp.Attempt{Count: 1, Res: res})
+			if actual < time.Second*5 || actual > time.Millisecond*6665 {
+				t.Fatalf("delay out of range: got %s, want between %s and %s", actual, time.Second*5, time.Millisecond*6665)
+			}
+		}
+	})
+
+	t.Run("should raise a jitter function's delay to retry-after with the flag on", func(t *testing.T) {
+		delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+			JitterFn: func(base time.Duration) time.Duration {
+				return base / 2
+			},
+			RetryAfterNoUndershoot: true,
+		})
+
+		if actual := delayFn(retryhttp.Attempt{Count: 1, Res: res}); actual != time.Second*5 {
+			t.Fatalf("unexpected delay: got %s, want %s", actual, time.Second*5)
+		}
+	})
+
+	t.Run("should undershoot retry-after with the flag off", func(t *testing.T) {
+		delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+			Base:            time.Millisecond * 100,
+			Cap:             time.Second,
+			JitterMagnitude: 0.333,
+		})
+
+		var undershot bool
+		for i := 0; i < 1000 && !undershot; i++ {
+			undershot = delayFn(retryhttp.Attempt{Count: 1, Res: res}) < time.Second*5
+		}
+		if !undershot {
+			t.Error("expected symmetric jitter to undershoot retry-after at least once")
+		}
+	})
+}
+
+func TestClampDelayFn(t *testing.T) {
+	tests := []struct {
+		name    string
+		inner   time.Duration
+		swapped bool // pass the bounds in the wrong order
+		want    time.Duration
+	}{
+		{
+			name:  "should raise a delay below the floor",
+			inner: time.Millisecond,
+			want:  time.Millisecond * 100,
+		},
+		{
+			name:  "should lower a delay above the ceiling",
+			inner: time.Hour,
+			want:  time.Second * 30,
+		},
+		{
+			name:  "should pass through a delay in range",
+			inner: time.Second,
+			want:  time.Second,
+		},
+		{
+			name:  "should pass through a delay equal to the floor",
+			inner: time.Millisecond * 100,
+			want:  time.Millisecond * 100,
+		},
+		{
+			name:    "should raise a delay below the floor with swapped bounds",
+			inner:   time.Millisecond,
+			swapped: true,
+			want:    time.Millisecond * 100,
+		},
+		{
+			name:    "should lower a delay above the ceiling with swapped bounds",
+			inner:   time.Hour,
+			swapped: true,
+			want:    time.Second * 30,
+		},
+		{
+			name:    "should pass through a delay in range with swapped bounds",
+			inner:   time.Second,
+			swapped: true,
+			want:    time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min, max := time.Millisecond*100, time.Second*30
+			if tt.swapped {
+				min, max = max, min
+			}
+			delayFn := retryhttp.ClampDelayFn(func(_ retryhttp.Attempt) time.Duration {
+				return tt.inner
+			}, min, max)
+
+			if actual := delayFn(retryhttp.Attempt{Count: 1}); actual != tt.want {
+				t.Errorf("actual != expected: got %s, want %s", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomizedDelayFnMaxJitter(t *testing.T) {
+	delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+		Base:            time.Millisecond * 100,
+		Cap:             time.Second,
+		JitterMagnitude: 0.333,
+		MaxJitter:       time.Second * 5,
+	})
+
+	tests := []struct {
+		name       string
+		retryAfter string
+		base       time.Duration
+		maxJitter  time.Duration
+	}{
+		{
+			name:       "should cap jitter for a large retry-after",
+			retryAfter: "3600",
+			base:       time.Hour,
+			maxJitter:  time.Second * 5,
+		},
+		{
+			name:       "should keep jitter proportional for a small retry-after",
+			retryAfter: "3",
+			base:       time.Second * 3,
+			maxJitter:  time.Millisecond * 999,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{"Retry-After": []string{tt.retryAfter}}}
+
+			var maxSeen time.Duration
+			for i := 0; i < 1000; i++ {
+				actual := delayFn(retryhttp.Attempt{Count: 1, Res: res})
+				jitter := actual - tt.base
+				if jitter < 0 {
+					jitter = -jitter
+				}
+				if jitter > tt.maxJitter {
+					t.Fatalf("jitter out of range: got %s, want at most %s", jitter, tt.maxJitter)
+				}
+				if jitter > maxSeen {
+					maxSeen = jitter
+				}
+			}
+
+			// jitter is uniform, so over many samples the bound is approached
+			if maxSeen < tt.maxJitter*3/4 {
+				t.Errorf("jitter never approached the bound: max %s, want close to %s", maxSeen, tt.maxJitter)
+			}
+		})
+	}
+}
+
+func TestNoRetryAfterSuccess(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+	}{
+		{
+			name:         "should not retry a 2xx that the inner policy would retry",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusAccepted, http.StatusServiceUnavailable},
+			wantAttempts: 2,
+		},
+		{
+			name:         "should defer to inner until a 2xx is seen",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			wantAttempts: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attemptCount := 0
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithShouldRetryFn(retryhttp.NoRetryAfterSuccess(retryhttp.DefaultShouldRetryFn)),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					status := tt.statuses[attemptCount]
+					attemptCount++
+					// the Retry-After header makes DefaultShouldRetryFn retry any status
+					return &http.Response{StatusCode: status, Header: http.Header{"Retry-After": []string{"0"}}, Body: http.NoBody}, nil
+				})),
+			)
+
+			req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected nil error but got %s", err)
+			}
+			res.Body.Close()
+
+			if attemptCount != tt.wantAttempts {
+				t.Fatalf("attempt count does not match expected; got %d, want %d", attemptCount, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestProblemDetailsShouldRetryFn(t *testing.T) {
+	shouldRetry := retryhttp.ProblemDetailsShouldRetryFn(retryhttp.DefaultShouldRetryFn)
+
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		contentType string
+		body        string
+		want        bool
+	}{
+		{
+			name:        "should retry a problem marked retryable",
+			method:      http.MethodGet,
+			status:      http.StatusInternalServerError,
+			contentType: "application/problem+json",
+			body:        `{"type":"https://example.com/probs/overloaded","title":"Overloaded","status":500,"retryable":true}`,
+			want:        true,
+		},
+		{
+			name:        "should not retry a problem marked not retryable",
+			method:      http.MethodGet,
+			status:      http.StatusServiceUnavailable,
+			contentType: "application/problem+json; charset=utf-8",
+			body:        `{"type":"https://example.com/probs/misconfigured","title":"Misconfigured","status":503,"retryable":false}`,
+			want:        false,
+		},
+		{
+			name:        "should not retry a retryable problem for a non-idempotent request",
+			method:      http.MethodPost,
+			status:      http.StatusInternalServerError,
+			contentType: "application/problem+json",
+			body:        `{"title":"Overloaded","retryable":true}`,
+			want:        false,
+		},
+		{
+			name:        "should defer to inner without a retryable member",
+			method:      http.MethodGet,
+			status:      http.StatusServiceUnavailable,
+			contentType: "application/problem+json",
+			body:        `{"title":"Unavailable","status":503}`,
+			want:        true,
+		},
+		{
+			name:        "should defer to inner for other content types",
+			method:      http.MethodGet,
+			status:      http.StatusInternalServerError,
+			contentType: "application/json",
+			body:        `{"retryable":true}`,
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{"Content-Type": []string{tt.contentType}},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+
+			actual := shouldRetry(retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: tt.method, Header: http.Header{}},
+				Res:   res,
+			})
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading body: %s", err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body was not restored: got %s, want %s", string(body), tt.body)
+			}
+		})
+	}
+}
+
+func TestHeaderDelayFn(t *testing.T) {
+	const fallback = time.Second * 7
+	delayFn := retryhttp.HeaderDelayFn("X-Backoff-Ms", func(_ retryhttp.Attempt) time.Duration {
+		return fallback
+	})
+
+	tests := []struct {
+		name   string
+		header http.Header
+		noRes  bool
+		want   time.Duration
+	}{
+		{
+			name:   "should use the delay from the header",
+			header: http.Header{"X-Backoff-Ms": []string{"1500"}},
+			want:   time.Millisecond * 1500,
+		},
+		{
+			name:   "should use a zero delay from the header",
+			header: http.Header{"X-Backoff-Ms": []string{"0"}},
+			want:   0,
+		},
+		{
+			name:   "should cap a huge delay from the header",
+			header: http.Header{"X-Backoff-Ms": []string{"99999999999999"}},
+			want:   time.Minute * 5,
+		},
+		{
+			name:   "should fall back when the header is absent",
+			header: http.Header{},
+			want:   fallback,
+		},
+		{
+			name:   "should fall back when the header is not a number",
+			header: http.Header{"X-Backoff-Ms": []string{"1.5s"}},
+			want:   fallback,
+		},
+		{
+			name:   "should fall back when the header is negative",
+			header: http.Header{"X-Backoff-Ms": []string{"-100"}},
+			want:   fallback,
+		},
+		{
+			name:  "should fall back when there is no response",
+			noRes: true,
+			want:  fallback,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := retryhttp.Attempt{Count: 1}
+			if !tt.noRes {
+				attempt.Res = &http.Response{StatusCode: http.StatusServiceUnavailable, Header: tt.header}
+			}
+
+			if actual := delayFn(attempt); actual != tt.want {
+				t.Errorf("actual != expected: got %s, want %s", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomizedDelayFnNoJitter(t *testing.T) {
+	delayFn := retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+		Base:            time.Millisecond * 250,
+		Cap:             time.Second * 10,
+		JitterMagnitude: 0.333,
+		NoJitter:        true,
+	})
+
+	tests := []struct {
+		name       string
+		count      int
+		retryAfter string
+		want       time.Duration
+	}{
+		{
+			name:  "should use the base for the first attempt",
+			count: 1,
+			want:  time.Millisecond * 250,
+		},
+		{
+			name:  "should double for the second attempt",
+			count: 2,
+			want:  time.Millisecond * 500,
+		},
+		{
+			name:  "should double again for the third attempt",
+			count: 3,
+			want:  time.Second,
+		},
+		{
+			name:  "should cap the backoff",
+			count: 10,
+			want:  time.Second * 10,
+		},
+		{
+			name:       "should use Retry-After exactly",
+			count:      1,
+			retryAfter: "3",
+			want:       time.Second * 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				res.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			// the result must not vary at all
+			for i := 0; i < 100; i++ {
+				if actual := delayFn(retryhttp.Attempt{Count: tt.count, Res: res}); actual != tt.want {
+					t.Fatalf("actual != expected: got %s, want %s", actual, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdaptShouldRetryFn(t *testing.T) {
+	fn := retryhttp.AdaptShouldRetryFn(func(attempt retryhttp.Attempt) bool {
+		return attempt.Res.StatusCode == http.StatusServiceUnavailable
+	})
+
+	for _, status := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		result := fn(retryhttp.Attempt{Count: 1, Res: &http.Response{StatusCode: status}})
+		want := retryhttp.ShouldRetryResult{Retry: status == http.StatusServiceUnavailable}
+		if result != want {
+			t.Errorf("actual != expected for status %d: got %+v, want %+v", status, result, want)
+		}
+	}
+}
+
+func TestCustomizedDelayFnRateLimitHeader(t *testing.T) {
+	options := retryhttp.CustomizedDelayFnOptions{
+		Base:               time.Millisecond * 250,
+		Cap:                time.Second * 10,
+		NoJitter:           true,
+		UseRateLimitHeader: true,
+	}
+
+	tests := []struct {
+		name       string
+		disabled   bool
+		rateLimit  string
+		retryAfter string
+		want       time.Duration
+	}{
+		{
+			name:      "should wait for reset when no requests remain",
+			rateLimit: "limit=100, remaining=0, reset=30",
+			want:      time.Second * 30,
+		},
+		{
+			name:      "should accept fields in any order and case",
+			rateLimit: "Reset=7,Remaining=0,Limit=10",
+			want:      time.Second * 7,
+		},
+		{
+			name:      "should ignore field parameters",
+			rateLimit: `limit=100;w=60, remaining=0;policy="default", reset=12`,
+			want:      time.Second * 12,
+		},
+		{
+			name:      "should back off normally when requests remain",
+			rateLimit: "limit=100, remaining=5, reset=30",
+			want:      time.Millisecond * 250,
+		},
+		{
+			name:      "should back off normally without a reset",
+			rateLimit: "limit=100, remaining=0",
+			want:      time.Millisecond * 250,
+		},
+		{
+			name:      "should back off normally with a malformed reset",
+			rateLimit: "limit=100, remaining=0, reset=soon",
+			want:      time.Millisecond * 250,
+		},
+		{
+			name:       "should prefer Retry-After",
+			rateLimit:  "limit=100, remaining=0, reset=30",
+			retryAfter: "3",
+			want:       time.Second * 3,
+		},
+		{
+			name:      "should ignore the header unless enabled",
+			disabled:  true,
+			rateLimit: "limit=100, remaining=0, reset=30",
+			want:      time.Millisecond * 250,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options
+			opts.UseRateLimitHeader = !tt.disabled
+			delayFn := retryhttp.CustomizedDelayFn(opts)
+
+			res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+			res.Header.Set("RateLimit", tt.rateLimit)
+			if tt.retryAfter != "" {
+				res.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			if actual := delayFn(retryhttp.Attempt{Count: 1, Res: res}); actual != tt.want {
+				t.Fatalf("actual != expected: got %s, want %s", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomizedDelayFnRand(t *testing.T) {
+	newDelayFn := func(seed int64) retryhttp.DelayFn {
+		return retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+			Base:            time.Millisecond * 250,
+			Cap:             time.Second * 10,
+			JitterMagnitude: 0.333,
+			Rand:            rand.New(rand.NewSource(seed)),
+		})
+	}
+	sequence := func(delayFn retryhttp.DelayFn) []time.Duration {
+		retryAfter := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"5"}}}
+		var delays []time.Duration
+		for count := 1; count <= 5; count++ {
+			delays = append(delays,
+				delayFn(retryhttp.Attempt{Count: count}),
+				delayFn(retryhttp.Attempt{Count: count, Res: retryAfter}),
+			)
+		}
+		return delays
+	}
+
+	t.Run("should reproduce the same delays with the same seed", func(t *testing.T) {
+		first, second := sequence(newDelayFn(42)), sequence(newDelayFn(42))
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("delays differ between identically seeded sources: %v and %v", first, second)
+		}
+		if other := sequence(newDelayFn(7)); reflect.DeepEqual(first, other) {
+			t.Fatalf("expected delays to differ with another seed, got %v", other)
+		}
+	})
+
+	t.Run("should be safe for concurrent use", func(t *testing.T) {
+		for _, delayFn := range []retryhttp.DelayFn{retryhttp.DefaultDelayFn, newDelayFn(42)} {
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						if d := delayFn(retryhttp.Attempt{Count: 3}); d < 0 || d > time.Second {
+							t.Errorf("delay out of range: got %s", d)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		}
+	})
+}
+
+func TestCustomizedShouldRetryFnConflictAndTooEarly(t *testing.T) {
+	tests := []struct {
+		name             string
+		optIn            bool
+		method           string
+		status           int
+		wantAttemptCount int
+	}{
+		{
+			name:             "should retry a 409 on a POST when opted in",
+			optIn:            true,
+			method:           http.MethodPost,
+			status:           http.StatusConflict,
+			wantAttemptCount: 2,
+		},
+		{
+			name:             "should retry a 425 on a PATCH when opted in",
+			optIn:            true,
+			method:           http.MethodPatch,
+			status:           http.StatusTooEarly,
+			wantAttemptCount: 2,
+		},
+		{
+			name:             "should not retry a 409 on a POST by default",
+			method:           http.MethodPost,
+			status:           http.StatusConflict,
+			wantAttemptCount: 1,
+		},
+		{
+			name:             "should not retry a 425 on a POST by default",
+			method:           http.MethodPost,
+			status:           http.StatusTooEarly,
+			wantAttemptCount: 1,
+		},
+		{
+			name:             "should not retry other client errors when opted in",
+			optIn:            true,
+			method:           http.MethodPost,
+			status:           http.StatusBadRequest,
+			wantAttemptCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attemptCount := 0
+			shouldRetry := retryhttp.CustomizedShouldRetryFn(retryhttp.CustomizedShouldRetryFnOptions{
+				IdempotentMethods:        []string{http.MethodGet},
+				RetryConflictAndTooEarly: tt.optIn,
+			})
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithMaxRetries(1),
+				retryhttp.WithShouldRetryFn(shouldRetry),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					attemptCount++
+					return &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: http.NoBody}, nil
+				})),
+			)
+
+			req, err := http.NewRequest(tt.method, "https://example.com", strings.NewReader(`{"version":3}`))
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected nil error but got %s", err)
+			}
+			res.Body.Close()
+
+			if attemptCount != tt.wantAttemptCount {
+				t.Fatalf("unexpected attempt count: got %d, want %d", attemptCount, tt.wantAttemptCount)
+			}
+		})
+	}
+}
+
+func TestAllServerErrorsShouldRetryFn(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+		want   bool
+	}{
+		{name: "should retry a 500 for a GET", method: http.MethodGet, status: http.StatusInternalServerError, want: true},
+		{name: "should retry a 504 for a GET", method: http.MethodGet, status: http.StatusGatewayTimeout, want: true},
+		{name: "should retry a 599 for a GET", method: http.MethodGet, status: 599, want: true},
+		{name: "should not retry a 500 for a POST", method: http.MethodPost, status: http.StatusInternalServerError, want: false},
+		{name: "should not retry a 504 for a POST", method: http.MethodPost, status: http.StatusGatewayTimeout, want: false},
+		{name: "should not retry a 599 for a POST", method: http.MethodPost, status: 599, want: false},
+		{name: "should not retry a 404 for a GET", method: http.MethodGet, status: http.StatusNotFound, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := retryhttp.AllServerErrorsShouldRetryFn(retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: tt.method},
+				Res:   &http.Response{StatusCode: tt.status},
+			})
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitBurstDelayFn(t *testing.T) {
+	const inner = time.Millisecond * 100
+
+	// the steps are applied in order to a single DelayFn, as a burst of responses
+	tests := []struct {
+		name   string
+		status int
+		want   time.Duration
+	}{
+		{
+			name:   "should not scale the first 429",
+			status: http.StatusTooManyRequests,
+			want:   inner,
+		},
+		{
+			name:   "should not scale a 429 up to the threshold",
+			status: http.StatusTooManyRequests,
+			want:   inner,
+		},
+		{
+			name:   "should scale the first 429 beyond the threshold",
+			status: http.StatusTooManyRequests,
+			want:   inner * 2,
+		},
+		{
+			name:   "should keep scaling as 429s continue",
+			status: http.StatusTooManyRequests,
+			want:   inner * 4,
+		},
+		{
+			name:   "should scale other failures while rate limited, without counting them",
+			status: http.StatusServiceUnavailable,
+			want:   inner * 4,
+		},
+		{
+			name:   "should scale further on the next 429",
+			status: http.StatusTooManyRequests,
+			want:   inner * 8,
+		},
+		{
+			name:   "should cap the scaled delay",
+			status: http.StatusTooManyRequests,
+			want:   time.Second,
+		},
+	}
+
+	delayFn := retryhttp.RateLimitBurstDelayFn(func(_ retryhttp.Attempt) time.Duration {
+		return inner
+	}, retryhttp.RateLimitBurstDelayFnOptions{
+		Window:    time.Minute,
+		Threshold: 2,
+		Cap:       time.Second,
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := retryhttp.Attempt{
+				Count: 1,
+				Res:   &http.Response{StatusCode: tt.status, Header: http.Header{}},
+			}
+			if actual := delayFn(attempt); actual != tt.want {
+				t.Errorf("actual != expected: got %s, want %s", actual, tt.want)
+			}
+		})
+	}
+
+	// a separate DelayFn doesn't see the burst
+	fresh := retryhttp.RateLimitBurstDelayFn(func(_ retryhttp.Attempt) time.Duration {
+		return inner
+	}, retryhttp.RateLimitBurstDelayFnOptions{Threshold: 2})
+	if actual := fresh(retryhttp.Attempt{Count: 1, Res: &http.Response{StatusCode: http.StatusTooManyRequests}}); actual != inner {
+		t.Errorf("expected an unscaled delay from a fresh DelayFn but got %s", actual)
+	}
+}
+
+func TestScheduleDelayFn(t *testing.T) {
+	schedule := []time.Duration{time.Second, time.Second * 5, time.Second * 30}
+
+	tests := []struct {
+		name   string
+		count  int
+		jitter float64
+		want   time.Duration
+	}{
+		{
+			name:  "should use the first entry after the first attempt",
+			count: 1,
+			want:  time.Second,
+		},
+		{
+			name:  "should use the second entry after the second attempt",
+			count: 2,
+			want:  time.Second * 5,
+		},
+		{
+			name:  "should use the last entry after the last scheduled attempt",
+			count: 3,
+			want:  time.Second * 30,
+		},
+		{
+			name:  "should clamp to the last entry past the end of the schedule",
+			count: 10,
+			want:  time.Second * 30,
+		},
+		{
+			name:   "should jitter within the magnitude",
+			count:  2,
+			jitter: 0.2,
+			want:   time.Second * 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delayFn := retryhttp.ScheduleDelayFn(schedule, tt.jitter)
+			attempt := retryhttp.Attempt{Count: tt.count}
+
+			if tt.jitter == 0 {
+				if actual := delayFn(attempt); actual != tt.want {
+					t.Errorf("actual != expected: got %s, want %s", actual, tt.want)
+				}
+				return
+			}
+
+			lower := time.Duration(float64(tt.want) * (1 - tt.jitter))
+			upper := time.Duration(float64(tt.want) * (1 + tt.jitter))
+			varied := false
+			for i := 0; i < 100; i++ {
+				actual := delayFn(attempt)
+				if actual < lower || actual > upper {
+					t.Fatalf("delay %s outside of [%s, %s]", actual, lower, upper)
+				}
+				if actual != tt.want {
+					varied = true
+				}
+			}
+			if !varied {
+				t.Error("expected jitter to vary the delay")
+			}
+		})
+	}
+
+	if actual := retryhttp.ScheduleDelayFn(nil, 0.2)(retryhttp.Attempt{Count: 1}); actual != 0 {
+		t.Errorf("expected no delay from an empty schedule but got %s", actual)
+	}
+}
+
+func TestReasonDelayFn(t *testing.T) {
+	constant := func(d time.Duration) retryhttp.DelayFn {
+		return func(_ retryhttp.Attempt) time.Duration {
+			return d
+		}
+	}
+	delayFn := retryhttp.ReasonDelayFn(map[retryhttp.RetryReason]retryhttp.DelayFn{
+		retryhttp.RetryReasonIdleConnClosed:                        constant(0),
+		retryhttp.StatusRetryReason(http.StatusTooManyRequests):    constant(time.Second * 30),
+		retryhttp.StatusRetryReason(http.StatusServiceUnavailable): constant(time.Second),
+	}, constant(time.Second*5))
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   time.Duration
+	}{
+		{
+			name: "should retry a closed idle connection immediately",
+			err:  errors.New("http: server closed idle connection"),
+			want: 0,
+		},
+		{
+			name:   "should use the strategy for 429s",
+			status: http.StatusTooManyRequests,
+			want:   time.Second * 30,
+		},
+		{
+			name:   "should use the strategy for 503s",
+			status: http.StatusServiceUnavailable,
+			want:   time.Second,
+		},
+		{
+			name:   "should fall back for a reason without a strategy",
+			status: http.StatusBadGateway,
+			want:   time.Second * 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delay time.Duration
+			tr := retryhttp.New(
+				retryhttp.WithMaxRetries(1),
+				// the chosen delay is recorded rather than waited
+				retryhttp.WithDelayFn(func(attempt retryhttp.Attempt) time.Duration {
+					delay = delayFn(attempt)
+					return 0
+				}),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: http.NoBody}, nil
+				})),
+			)
+
+			req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			res, err := tr.RoundTrip(req)
+			if err == nil {
+				res.Body.Close()
+			}
+
+			if delay != tt.want {
+				t.Errorf("actual != expected: got %s, want %s", delay, tt.want)
+			}
+		})
+	}
+
+	if actual := delayFn(retryhttp.Attempt{Count: 1}); actual != time.Second*5 {
+		t.Errorf("expected an attempt without a reason to fall back but got %s", actual)
+	}
+}
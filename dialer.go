@@ -0,0 +1,49 @@
+package retryhttp
+
+import (
+	"context"
+	"net"
+)
+
+// RotatingDialer dials a single resolved address of a host, chosen by how many times the
+// request has been rotated away from a failing address by [Transport]. Use its Dial method
+// as the DialContext of the internal [http.Transport], and enable rotation using
+// [WithAddressRotation]. Outside of a rotated request it dials the first resolved address.
+type RotatingDialer struct {
+	// DialContext dials a single address. If nil, a zero [net.Dialer] is used.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// LookupHost resolves a host to its addresses. If nil, [net.DefaultResolver] is used.
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// Dial dials address, which must be of the form "host:port". If the host is a name that
+// resolves to more than one address, the address to dial is picked by the rotation stored
+// in ctx by [Transport].
+func (d *RotatingDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := d.DialContext
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(ctx, network, address)
+	}
+
+	lookupHost := d.LookupHost
+	if lookupHost == nil {
+		lookupHost = net.DefaultResolver.LookupHost
+	}
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	rotation, _ := ctx.Value(addressRotationContextKey).(int)
+	return dial(ctx, network, net.JoinHostPort(addrs[rotation%len(addrs)], port))
+}
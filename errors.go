@@ -1,10 +1,104 @@
 package retryhttp
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"strings"
 )
 
+// RetriesInterruptedError is returned by [Transport] when the parent context expires during
+// the delay before a retry. It unwraps to the context's error, so errors.Is(err,
+// context.Canceled) and errors.Is(err, context.DeadlineExceeded) continue to work, and it
+// also matches [ErrRetriesInterrupted]. It is also returned, unwrapping to
+// [ErrRetryGroupSucceeded], when another member of the request's [RetryGroup] succeeds
+// during the delay.
+type RetriesInterruptedError struct {
+	// Attempts is the number of attempts made before the retries were interrupted.
+	Attempts int
+
+	// StatusCode is the status of the last response received, or 0 if the last attempt
+	// ended in an error.
+	StatusCode int
+
+	// Err is the parent context's error, or [ErrRetryGroupSucceeded] if the retries were
+	// interrupted because another member of the request's [RetryGroup] succeeded.
+	Err error
+}
+
+func (e *RetriesInterruptedError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s after %d attempts (last status %d): %s", ErrRetriesInterrupted, e.Attempts, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s after %d attempts: %s", ErrRetriesInterrupted, e.Attempts, e.Err)
+}
+
+func (e *RetriesInterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is [ErrRetriesInterrupted].
+func (e *RetriesInterruptedError) Is(target error) bool {
+	return target == ErrRetriesInterrupted
+}
+
+// RetriesExhaustedError is returned by [Transport] when [WithRetriesExhaustedError] is
+// enabled and the last attempt allowed by the maximum number of retries would otherwise
+// have been retried. It matches [ErrRetriesExhausted] using errors.Is, and unwraps to the
+// last attempt's error, if any.
+//
+// The last attempt's response, if any, is returned alongside this error as well as in
+// Response, and the caller is responsible for closing its body. Note that [http.Client]
+// discards a response that is returned with an error, so callers using one must close it
+// using Response instead.
+type RetriesExhaustedError struct {
+	// Attempts is the number of attempts made.
+	Attempts int
+
+	// StatusCode is the status of the last response, or 0 if the last attempt ended in an
+	// error.
+	StatusCode int
+
+	// Response is the last response, or nil if the last attempt ended in an error.
+	Response *http.Response
+
+	// Err is the last attempt's error, or nil if it returned a response.
+	Err error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s after %d attempts: %s", ErrRetriesExhausted, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("%s after %d attempts (last status %d)", ErrRetriesExhausted, e.Attempts, e.StatusCode)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is [ErrRetriesExhausted].
+func (e *RetriesExhaustedError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+// RetryAfterParseError describes a Retry-After header that could be parsed neither as a
+// number of seconds nor as an HTTP date. It is reported by the callback configured with
+// [WithRetryAfterParseErrorRecorder]; it is never returned by [Transport], since the delay
+// falls back to the [DelayFn]'s usual backoff.
+type RetryAfterParseError struct {
+	// Value is the malformed value of the header.
+	Value string
+}
+
+func (e *RetryAfterParseError) Error() string {
+	return fmt.Sprintf("malformed Retry-After header %q", e.Value)
+}
+
 // IsDNSErr is used to determine if an error from an attempt is due to DNS. Requests that
 // failed with a DNS error
 func IsDNSErr(err error) bool {
@@ -12,9 +106,95 @@ func IsDNSErr(err error) bool {
 	return errors.As(err, &dnse)
 }
 
+// errServerClosedIdle is the message of the error net/http returns when the server closed a
+// keep-alive connection while it was idle.
+const errServerClosedIdle = "http: server closed idle connection"
+
+// IsIdleConnClosedErr is used to determine if an error from an attempt is due to the server
+// closing a reused keep-alive connection, typically because the server's idle timeout fired
+// just as the connection was picked for the request. [http.Transport] retries these itself,
+// but only for requests it considers replayable, so others are reported to the caller.
+// Note that the request may still have been transmitted: net/http also reports this error
+// when the server's close crossed paths with request body bytes that were already written
+// (see https://github.com/golang/go/issues/19943), so it is only safe to retry for
+// idempotent requests. An EOF after the request was written, or partway through the
+// response, is not reported as one.
+func IsIdleConnClosedErr(err error) bool {
+	// net/http doesn't export this error, so it is recognized by its message
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if e.Error() == errServerClosedIdle {
+			return true
+		}
+	}
+
+	return isNothingWrittenErr(err)
+}
+
+// isNothingWrittenErr reports whether err is net/http's wrapper for an error on a reused
+// connection before any of the request was written to it, which makes it safe to retry
+// regardless of method. [http.Transport] usually unwraps it before returning, but a wrapping
+// RoundTripper may pass it on.
+func isNothingWrittenErr(err error) bool {
+	// nor does it export this one, so it is recognized by its type name
+	for ; err != nil; err = errors.Unwrap(err) {
+		if fmt.Sprintf("%T", err) == "http.nothingWrittenError" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDialErr is used to determine if an error from an attempt is due to failing to establish
+// a connection, such as a refused or unreachable connection, as reported by a
+// [*net.OpError] whose Op is "dial". Since no connection was made, none of the request was
+// sent, so unlike a read or write failure it is safe to retry regardless of method. A dial
+// that timed out is also reported by [IsTimeoutErr].
+func IsDialErr(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
 // IsTimeoutErr is used to determine if an error from an attempt is due to a common timeout.
 // This includes network timeouts or the context deadline being exceeded.
 func IsTimeoutErr(err error) bool {
 	var netErr net.Error
 	return errors.As(err, &netErr) && netErr.Timeout()
 }
+
+// IsCanceledErr is used to determine if an error from an attempt is due to the request's
+// context being canceled, even if the cancellation is wrapped inside another error such as
+// a [*net.OpError] or [*url.Error]. A canceled request was explicitly aborted by the caller
+// and should never be retried.
+func IsCanceledErr(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// IsTLSHandshakeErr is used to determine if an error from an attempt is due to a TLS
+// handshake that failed for a reason that may be transient, such as a malformed record or a
+// handshake failure alert from an overloaded or misrouted peer. It is deliberately
+// conservative: certificate validation failures (an expired, untrusted, or mismatched
+// certificate) are deterministic and are never reported as handshake errors.
+func IsTLSHandshakeErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &invalidErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) {
+		return false
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "x509:") || strings.Contains(msg, "certificate") {
+		return false
+	}
+
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		return true
+	}
+
+	return strings.Contains(msg, "tls: handshake failure") || strings.Contains(msg, "TLS handshake timeout")
+}
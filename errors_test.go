@@ -1,10 +1,21 @@
 package retryhttp_test
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/justinrixx/retryhttp"
 )
@@ -54,6 +65,117 @@ func TestIsDNSErr(t *testing.T) {
 	}
 }
 
+func TestIsDialErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "returns true for a refused connection",
+			err: &url.Error{
+				Op:  "Post",
+				URL: "http://example.com",
+				Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			},
+			want: true,
+		},
+		{
+			name: "returns true for a dial that timed out",
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: timeoutErr{}},
+			want: true,
+		},
+		{
+			name: "returns false for a failed read",
+			err:  &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+			want: false,
+		},
+		{
+			name: "returns false for a failed write",
+			err:  &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken pipe")},
+			want: false,
+		},
+		{
+			name: "returns false for a non network error",
+			err:  errors.New("fake error"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryhttp.IsDialErr(tt.err); got != tt.want {
+				t.Errorf("IsDialErr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsIdleConnClosedErrFromReusedConn(t *testing.T) {
+	// the server answers one request on a keep-alive connection, and then closes it just as
+	// the client picks it for the next request, a non-replayable POST
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer l.Close()
+	closeConn := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		<-closeConn
+	}()
+
+	rt := &http.Transport{}
+	defer rt.CloseIdleConnections()
+	req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected nil error but got %s", err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	// give the client time to notice the close before it uses the connection
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				close(closeConn)
+				time.Sleep(time.Millisecond * 100)
+			}
+		},
+	})
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, "http://"+l.Addr().String(), strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	req.GetBody = nil
+	_, err = rt.RoundTrip(req)
+	if !retryhttp.IsIdleConnClosedErr(err) {
+		t.Fatalf("expected an idle connection error but got %v", err)
+	}
+
+	// net/http can't tell whether the body was written, so only idempotent requests retry
+	if retryhttp.DefaultShouldRetryFn(retryhttp.Attempt{Count: 1, Req: req, Err: err}) {
+		t.Error("expected a POST that failed on a closed idle connection not to be retried")
+	}
+	req.Header.Set("Idempotency-Key", "key")
+	if !retryhttp.DefaultShouldRetryFn(retryhttp.Attempt{Count: 1, Req: req, Err: err}) {
+		t.Error("expected an idempotent request that failed on a closed idle connection to be retried")
+	}
+}
+
 func TestIsTimeoutErr(t *testing.T) {
 	tests := []struct {
 		name string
@@ -86,3 +208,211 @@ func TestIsTimeoutErr(t *testing.T) {
 		})
 	}
 }
+
+func TestIsCanceledErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "returns true for context canceled",
+			err:  context.Canceled,
+			want: true,
+		},
+		{
+			name: "returns true for context canceled wrapped in a net.OpError",
+			err: &net.OpError{
+				Op:  "dial",
+				Err: context.Canceled,
+			},
+			want: true,
+		},
+		{
+			name: "returns true for context canceled wrapped in a url.Error",
+			err: &url.Error{
+				Op:  "Get",
+				URL: "http://example.com",
+				Err: &net.OpError{Op: "read", Err: context.Canceled},
+			},
+			want: true,
+		},
+		{
+			name: "returns true for context canceled wrapped with fmt.Errorf",
+			err:  fmt.Errorf("doing request: %w", context.Canceled),
+			want: true,
+		},
+		{
+			name: "returns false for context deadline exceeded",
+			err:  context.DeadlineExceeded,
+			want: false,
+		},
+		{
+			name: "returns false for non-cancellation error",
+			err:  errors.New("fake error"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryhttp.IsCanceledErr(tt.err); got != tt.want {
+				t.Errorf("IsCanceledErr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTLSHandshakeErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "returns true for a malformed tls record",
+			err: &url.Error{
+				Op:  "Get",
+				URL: "https://example.com",
+				Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"},
+			},
+			want: true,
+		},
+		{
+			name: "returns true for a handshake failure alert",
+			err: &url.Error{
+				Op:  "Get",
+				URL: "https://example.com",
+				Err: &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")},
+			},
+			want: true,
+		},
+		{
+			name: "returns false for an untrusted certificate",
+			err: &url.Error{
+				Op:  "Get",
+				URL: "https://example.com",
+				Err: x509.UnknownAuthorityError{},
+			},
+			want: false,
+		},
+		{
+			name: "returns false for an expired certificate",
+			err: &url.Error{
+				Op:  "Get",
+				URL: "https://example.com",
+				Err: x509.CertificateInvalidError{Reason: x509.Expired},
+			},
+			want: false,
+		},
+		{
+			name: "returns false for a bad certificate alert",
+			err:  &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")},
+			want: false,
+		},
+		{
+			name: "returns false for non-tls error",
+			err:  errors.New("fake error"),
+			want: false,
+		},
+		{
+			name: "returns false for nil",
+			err:  nil,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryhttp.IsTLSHandshakeErr(tt.err); got != tt.want {
+				t.Errorf("IsTLSHandshakeErr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTLSHandshakeErrFromServer(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// the default client doesn't trust the test server's certificate
+	_, err := http.Get(ts.URL)
+	if err == nil {
+		t.Fatal("expected certificate validation error but got nil")
+	}
+	if retryhttp.IsTLSHandshakeErr(err) {
+		t.Errorf("expected certificate validation error not to be a handshake error: %s", err)
+	}
+}
+
+func TestIsIdleConnClosedErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "returns true for a server closed idle connection",
+			err: &url.Error{
+				Op:  "Post",
+				URL: "http://example.com",
+				Err: errors.New("http: server closed idle connection"),
+			},
+			want: true,
+		},
+		{
+			name: "returns false for an unexpected EOF partway through a response",
+			err:  fmt.Errorf("error reading body: %w", io.ErrUnexpectedEOF),
+			want: false,
+		},
+		{
+			name: "returns false for a reset connection",
+			err:  &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+			want: false,
+		},
+		{
+			name: "returns false for nil",
+			err:  nil,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryhttp.IsIdleConnClosedErr(tt.err); got != tt.want {
+				t.Errorf("IsIdleConnClosedErr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsIdleConnClosedErrFromServer(t *testing.T) {
+	// the server reads the whole request and then hangs up without responding, so the
+	// request was transmitted and may have been processed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err == nil {
+			io.Copy(io.Discard, req.Body)
+		}
+		conn.Close()
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+l.Addr().String(), strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	req.GetBody = nil
+	_, err = http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	if retryhttp.IsIdleConnClosedErr(err) {
+		t.Errorf("expected an EOF after the request was sent not to be an idle connection error: %s", err)
+	}
+}
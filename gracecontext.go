@@ -0,0 +1,80 @@
+package retryhttp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// graceContext is the context of an attempt made with a cancellation grace, as configured
+// with [WithCancellationGrace]. It carries the values of its parent, but is only canceled
+// once the grace period has passed since its parent was canceled, or when it is canceled
+// directly.
+type graceContext struct {
+	context.Context // the parent, for its values
+
+	grace time.Duration
+	done  chan struct{}
+	stop  chan struct{}
+	once  sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+func withCancellationGrace(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	g := &graceContext{
+		Context: parent,
+		grace:   grace,
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-g.stop:
+			return
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			g.cancel(parent.Err())
+		case <-g.stop:
+		}
+	}()
+
+	return g, func() {
+		g.cancel(context.Canceled)
+	}
+}
+
+func (g *graceContext) cancel(err error) {
+	g.once.Do(func() {
+		g.mu.Lock()
+		g.err = err
+		g.mu.Unlock()
+		close(g.done)
+		close(g.stop)
+	})
+}
+
+func (g *graceContext) Deadline() (time.Time, bool) {
+	deadline, ok := g.Context.Deadline()
+	if !ok {
+		return deadline, false
+	}
+	return deadline.Add(g.grace), true
+}
+
+func (g *graceContext) Done() <-chan struct{} {
+	return g.done
+}
+
+func (g *graceContext) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
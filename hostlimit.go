@@ -0,0 +1,204 @@
+package retryhttp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// hostLimiter bounds how many attempts are in flight to each host at once. See
+// [WithMaxAttemptsPerHost].
+type hostLimiter struct {
+	limit    int
+	failFast bool
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+// hostSlots is the semaphore for a single host. It is removed from the map once no attempt
+// holds or is waiting for one of its slots, so that the map doesn't grow with every host
+// ever seen.
+type hostSlots struct {
+	sem  chan struct{}
+	refs int
+}
+
+func newHostLimiter(limit int, failFast bool) *hostLimiter {
+	return &hostLimiter{
+		limit:    limit,
+		failFast: failFast,
+		hosts:    map[string]*hostSlots{},
+	}
+}
+
+// acquire takes one of host's slots, waiting for one to free up unless the limiter fails
+// fast. If it returns a nil error, release must be called once the attempt is done.
+func (l *hostLimiter) acquire(ctx context.Context, host string) (release func(), err error) {
+	l.mu.Lock()
+	slots, ok := l.hosts[host]
+	if !ok {
+		slots = &hostSlots{sem: make(chan struct{}, l.limit)}
+		l.hosts[host] = slots
+	}
+	slots.refs++
+	l.mu.Unlock()
+
+	if l.failFast {
+		select {
+		case slots.sem <- struct{}{}:
+		default:
+			l.unref(host, slots)
+			return nil, ErrHostConcurrencyLimit
+		}
+	} else {
+		select {
+		case slots.sem <- struct{}{}:
+		case <-ctx.Done():
+			l.unref(host, slots)
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		<-slots.sem
+		l.unref(host, slots)
+	}, nil
+}
+
+func (l *hostLimiter) unref(host string, slots *hostSlots) {
+	l.mu.Lock()
+	slots.refs--
+	if slots.refs == 0 {
+		delete(l.hosts, host)
+	}
+	l.mu.Unlock()
+}
+
+// hostSpacer spaces out the starts of attempts to each host. See [WithMinInterval].
+type hostSpacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time // the earliest time the next attempt to each host may start
+}
+
+func newHostSpacer(interval time.Duration) *hostSpacer {
+	return &hostSpacer{
+		interval: interval,
+		next:     map[string]time.Time{},
+	}
+}
+
+// reserve claims the earliest start time available to an attempt to host, and returns how
+// long the attempt must wait for it.
+func (s *hostSpacer) reserve(host string, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := now
+	if next, ok := s.next[host]; ok && next.After(now) {
+		start = next
+	}
+	s.next[host] = start.Add(s.interval)
+
+	// hosts that have gone quiet don't need to be remembered
+	if len(s.next) > hostSpacerPruneSize {
+		for h, next := range s.next {
+			if !next.After(now) {
+				delete(s.next, h)
+			}
+		}
+	}
+
+	return start.Sub(now)
+}
+
+// hostSpacerPruneSize is how many hosts a hostSpacer remembers before forgetting the ones
+// whose interval has passed.
+const hostSpacerPruneSize = 256
+
+// wait blocks until an attempt to host may start, or ctx is done.
+func (s *hostSpacer) wait(ctx context.Context, host string) error {
+	d := s.reserve(host, time.Now())
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hostBackoff tracks the failures of each host across requests, so that backoff grows with
+// a host's run of failures rather than with each request's attempts, and starts over once
+// the host has gone the reset interval without failing. See [WithHostBackoff].
+type hostBackoff struct {
+	resetInterval time.Duration
+
+	mu       sync.Mutex
+	failures map[string]hostFailures
+}
+
+type hostFailures struct {
+	streak int       // the failures in the host's current run
+	last   time.Time // when the last of them happened
+}
+
+func newHostBackoff(resetInterval time.Duration) *hostBackoff {
+	return &hostBackoff{
+		resetInterval: resetInterval,
+		failures:      map[string]hostFailures{},
+	}
+}
+
+// fail records a failure of host by an attempt sent at started, and returns the length of
+// the host's current run of failures. Concurrent requests that fail together are one
+// failure of the host, so a failure only extends the run if no other failure was counted
+// since the attempt was sent.
+func (b *hostBackoff) fail(host string, started, now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f := b.failures[host]
+	if now.Sub(f.last) >= b.resetInterval {
+		f.streak = 0
+	}
+	if f.streak > 0 && !started.IsZero() && f.last.After(started) {
+		return f.streak
+	}
+	f.streak++
+	f.last = now
+	b.failures[host] = f
+
+	// hosts that have recovered would start over anyway
+	if len(b.failures) > hostSpacerPruneSize {
+		for h, f := range b.failures {
+			if now.Sub(f.last) >= b.resetInterval {
+				delete(b.failures, h)
+			}
+		}
+	}
+
+	return f.streak
+}
+
+// delayFn wraps inner so that the attempt count it backs off by is the host's run of
+// failures.
+func (b *hostBackoff) delayFn(inner DelayFn) DelayFn {
+	return func(attempt Attempt) time.Duration {
+		host := attempt.host
+		if host == "" && attempt.Req != nil && attempt.Req.URL != nil {
+			host = attempt.Req.URL.Host
+		}
+		if host != "" {
+			attempt.Count = b.fail(host, attempt.started, time.Now())
+		}
+		return inner(attempt)
+	}
+}
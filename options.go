@@ -2,24 +2,49 @@ package retryhttp
 
 import (
 	"context"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 type (
-	maxRetriesContextKeyType           string
-	shouldRetryFnContextKeyType        string
-	delayFnContextKeyType              string
-	preventRetryWithBodyContextKeyType string
-	attemptTimeoutContextKeyType       string
+	maxRetriesContextKeyType            string
+	shouldRetryFnContextKeyType         string
+	delayFnContextKeyType               string
+	preventRetryWithBodyContextKeyType  string
+	attemptTimeoutContextKeyType        string
+	retryOnTrailerFnContextKeyType      string
+	allowRetryWithGetBodyContextKeyType string
+	retryAfterCapContextKeyType         string
+	connReusePolicyContextKeyType       string
+	disabledContextKeyType              string
+	assumeIdempotentContextKeyType      string
+	maxRetriesPerStatusContextKeyType   string
+	refuseBodyBufferingContextKeyType   string
+	attemptCountContextKeyType          string
+	addressRotationContextKeyType       string
+	retryGroupContextKeyType            string
 )
 
 const (
-	maxRetriesContextKey           = maxRetriesContextKeyType("maxRetries")
-	shouldRetryFnContextKey        = shouldRetryFnContextKeyType("shouldRetryFn")
-	delayFnContextKey              = delayFnContextKeyType("delayFn")
-	preventRetryWithBodyContextKey = preventRetryWithBodyContextKeyType("preventRetryWithBody")
-	attemptTimeoutContextKey       = attemptTimeoutContextKeyType("attemptTimeout")
+	maxRetriesContextKey            = maxRetriesContextKeyType("maxRetries")
+	shouldRetryFnContextKey         = shouldRetryFnContextKeyType("shouldRetryFn")
+	delayFnContextKey               = delayFnContextKeyType("delayFn")
+	preventRetryWithBodyContextKey  = preventRetryWithBodyContextKeyType("preventRetryWithBody")
+	attemptTimeoutContextKey        = attemptTimeoutContextKeyType("attemptTimeout")
+	retryOnTrailerFnContextKey      = retryOnTrailerFnContextKeyType("retryOnTrailerFn")
+	allowRetryWithGetBodyContextKey = allowRetryWithGetBodyContextKeyType("allowRetryWithGetBody")
+	retryAfterCapContextKey         = retryAfterCapContextKeyType("retryAfterCap")
+	connReusePolicyContextKey       = connReusePolicyContextKeyType("connReusePolicy")
+	disabledContextKey              = disabledContextKeyType("disabled")
+	assumeIdempotentContextKey      = assumeIdempotentContextKeyType("assumeIdempotent")
+	maxRetriesPerStatusContextKey   = maxRetriesPerStatusContextKeyType("maxRetriesPerStatus")
+	refuseBodyBufferingContextKey   = refuseBodyBufferingContextKeyType("refuseBodyBuffering")
+	attemptCountContextKey          = attemptCountContextKeyType("attemptCount")
+	addressRotationContextKey       = addressRotationContextKeyType("addressRotation")
+	retryGroupContextKey            = retryGroupContextKeyType("retryGroup")
 )
 
 // WithTransport configures a Transport with an internal roundtripper of its own.
@@ -30,6 +55,27 @@ func WithTransport(transport http.RoundTripper) func(*Transport) {
 	}
 }
 
+// WithDisabled configures whether retry behavior is disabled entirely. A disabled
+// Transport passes each request straight through to its internal roundtripper: a single
+// attempt is made, the request body is not buffered, and no other options apply. This is
+// useful as a kill switch, for example during an incident where retries make things worse.
+func WithDisabled(disabled bool) func(*Transport) {
+	return func(t *Transport) {
+		t.disabled = disabled
+	}
+}
+
+// WithRetryPathMatcher configures a predicate on the request URL that decides whether the
+// Transport applies to a request at all, for coarse per-route control from one shared
+// Transport, for example retrying /api/ but never /webhooks/. A request whose URL doesn't
+// match is passed straight through to the internal roundtripper, as if the Transport were
+// disabled with [WithDisabled]. If no matcher is configured, every request matches.
+func WithRetryPathMatcher(matcher func(u *url.URL) bool) func(*Transport) {
+	return func(t *Transport) {
+		t.retryPathMatcher = matcher
+	}
+}
+
 // WithMaxRetries configures the maximum number of retries a Transport is allowed to make.
 // If not set, defaults to [DefaultMaxRetries]. Note that this number does not include the
 // initial attempt, so if this is configured as 3, there could be up to 4 total attempts.
@@ -39,31 +85,450 @@ func WithMaxRetries(maxRetries int) func(*Transport) {
 	}
 }
 
-// WithShouldRetryFn configures the [ShouldRetryFn] callback to use.
+// WithMaxRetriesPerStatus configures status-specific retry limits, mapping a response
+// status code to the maximum number of retries that may follow a response with that
+// status. For example, {429: 5, 503: 2} retries 429s up to five times but 503s only twice
+// within a single request. A limit for a status takes the place of the limit configured
+// with [WithMaxRetries]; statuses without an entry (and errors) fall back to it. The
+// ceiling configured with [WithHardMaxRetries] still applies to the total.
+func WithMaxRetriesPerStatus(maxRetriesPerStatus map[int]int) func(*Transport) {
+	return func(t *Transport) {
+		t.maxRetriesPerStatus = maxRetriesPerStatus
+	}
+}
+
+// WithHardMaxRetries configures a ceiling on the number of retries a Transport is allowed
+// to make. Unlike [WithMaxRetries], this cannot be overridden using the request context:
+// any value provided by [SetMaxRetries] is clamped to it. This protects dependencies from
+// callers that set an unreasonably high retry count.
+func WithHardMaxRetries(hardMaxRetries int) func(*Transport) {
+	return func(t *Transport) {
+		t.hardMaxRetries = &hardMaxRetries
+	}
+}
+
+// WithMaxInFlightRetries configures a limit on the number of retries a Transport may
+// have in flight at once across all requests. Initial attempts are not counted or
+// limited. A retry occupies a slot from the moment it is decided on (including the
+// delay beforehand) until its round trip completes. When every slot is occupied, no
+// further retries are made and the last response is returned as is. This prevents a
+// widespread failure from multiplying the load on a dependency by the number of retries.
+// A value of 0 or less means in-flight retries are not limited.
+func WithMaxInFlightRetries(maxInFlightRetries int) func(*Transport) {
+	return func(t *Transport) {
+		t.retrySem = nil
+		if maxInFlightRetries > 0 {
+			t.retrySem = make(chan struct{}, maxInFlightRetries)
+		}
+	}
+}
+
+// WithMaxAttemptsPerHost configures a hard limit on the number of attempts, initial attempts
+// and retries alike, a Transport may have in flight to each host at once, to avoid
+// overwhelming a single backend during an incident. Hosts are told apart by the host and
+// port of the request URL. An attempt occupies a slot until its round trip returns, which
+// doesn't include reading the response body. When every slot for a host is occupied, an
+// attempt waits for one to free up, bounded by the request's context and attempt timeout,
+// or if failFast is true, fails immediately with [ErrHostConcurrencyLimit]. A limit of 0 or
+// less means attempts per host are unlimited.
+func WithMaxAttemptsPerHost(limit int, failFast bool) func(*Transport) {
+	return func(t *Transport) {
+		t.hostLimiter = nil
+		if limit > 0 {
+			t.hostLimiter = newHostLimiter(limit, failFast)
+		}
+	}
+}
+
+// WithMinInterval configures a minimum interval between the starts of any two attempts to
+// the same host, initial attempts and retries alike, across all of the Transport's
+// requests. An attempt that would start too soon after another waits until the interval has
+// passed, bounded by the request's context and attempt timeout. This rate-limits requests to
+// a fragile backend in a way per-request backoff can't, since it coordinates concurrent
+// requests. Hosts are told apart by the host and port of the request URL. A value of 0 or
+// less means attempts aren't spaced out.
+func WithMinInterval(d time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.hostSpacer = nil
+		if d > 0 {
+			t.hostSpacer = newHostSpacer(d)
+		}
+	}
+}
+
+// WithHostBackoff configures a [CustomizedDelayFn] whose exponential backoff grows with
+// each host's run of failures across all of the Transport's requests, rather than with the
+// attempts of each request. The run starts over once a host has gone resetInterval without a
+// failure, so in a long-lived client an isolated failure hours after an outage doesn't
+// inherit the outage's large backoff, while requests made during an outage keep backing off
+// further. Concurrent requests that fail together count as a single failure of the host,
+// so the backoff grows with rounds of failures rather than with how many requests are in
+// flight. Hosts are told apart by the host and port each attempt was sent to, which is a
+// failover host's for retries sent to one using [WithFailoverHosts]. A resetInterval of 0
+// or less means 1 minute. This replaces any configured [DelayFn] or [Backoff].
+func WithHostBackoff(options CustomizedDelayFnOptions, resetInterval time.Duration) func(*Transport) {
+	if resetInterval <= 0 {
+		resetInterval = time.Minute
+	}
+
+	return func(t *Transport) {
+		t.hostBackoff = newHostBackoff(resetInterval)
+		t.delayFn = t.hostBackoff.delayFn(CustomizedDelayFn(options))
+		t.newBackoff = nil
+	}
+}
+
+// WithRetryBudget configures a limit on retries as a fraction of requests. The Transport
+// counts the requests and retries it has made over the last 10 seconds, and a retry is
+// only made if it would keep retries at or below fraction of requests. For example, a
+// budget of 0.1 allows at most one retry for every ten requests. When the budget is
+// exhausted, the last response is returned as is. This is a simple guard against retries
+// amplifying load on a struggling dependency. A value of 0 or less means retries are not
+// budgeted.
+func WithRetryBudget(fraction float64) func(*Transport) {
+	return func(t *Transport) {
+		t.retryBudget = nil
+		if fraction > 0 {
+			t.retryBudget = newRetryBudget(fraction)
+		}
+	}
+}
+
+// WithMaxBufferedBytes configures a limit on how many bytes of request bodies the Transport
+// buffers into memory at once, across all of its in-flight requests. A body that would take
+// the total over the limit isn't buffered; its request is sent once, with the body streamed
+// as is, and isn't retried. The bytes of a buffered body count against the limit until its
+// request returns. This bounds the memory used by bursts of large uploads. A value of 0 or
+// less means buffered bytes are unlimited.
+func WithMaxBufferedBytes(n int64) func(*Transport) {
+	return func(t *Transport) {
+		t.bufferBudget = nil
+		if n > 0 {
+			t.bufferBudget = &bufferBudget{limit: n}
+		}
+	}
+}
+
+// WithOnThrottle configures a callback that is invoked whenever a retry that the
+// [ShouldRetryFn] asked for is suppressed by the Transport's own limits, configured with
+// [WithMaxInFlightRetries] or [WithRetryBudget]. It is called with the attempt whose retry
+// was suppressed, just before its response is returned, which makes it useful for capacity
+// planning and alerting.
+func WithOnThrottle(onThrottle func(attempt Attempt)) func(*Transport) {
+	return func(t *Transport) {
+		t.onThrottle = onThrottle
+	}
+}
+
+// WithOverloadSignal configures a predicate that decides whether an attempt's outcome is
+// a sign that the destination is overloaded, such as a 503 or a 429, as opposed to a
+// failure like a DNS error that more load doesn't make worse. Its decision is exposed as
+// [Attempt.Overloaded], and only retries of overloaded attempts are subject to
+// [WithMaxInFlightRetries] and [WithRetryBudget]. This keeps the definition of overload in
+// one place, independent of how retries are limited. By default every attempt is
+// considered overloaded, so every retry is limited.
+func WithOverloadSignal(overloadSignal func(attempt Attempt) bool) func(*Transport) {
+	return func(t *Transport) {
+		t.overloadSignal = overloadSignal
+	}
+}
+
+// WithRetryGate configures a process-wide switch for retries. While the gate returns false,
+// requests are limited to a single attempt, as if retries were exhausted; once it returns
+// true again, retries resume. Unlike [WithDisabled], which is fixed when the [Transport] is
+// created or set per request, the gate is consulted before every retry and is meant to be
+// flipped by something outside the request path, such as a health monitor pausing retries
+// during an incident to avoid adding load. Everything else, including body buffering,
+// still applies while the gate is closed.
+func WithRetryGate(gate RetryGate) func(*Transport) {
+	return func(t *Transport) {
+		t.retryGate = gate
+	}
+}
+
+// WithRetryPrecondition configures a check that must pass before each retry. It is only
+// consulted once the [ShouldRetryFn] has decided to retry, and receives the request's
+// context. If it returns false, no retry is made and the last attempt's outcome is returned
+// immediately. It is intended for a cheap local check, such as a health check saying the
+// destination is down hard, to avoid retrying into a backend that is known to be dead.
+// Unlike [WithMaxInFlightRetries] and [WithRetryBudget], it is not a limit on retries but
+// a yes or no answer, and [WithOnThrottle] is not called when it blocks a retry.
+func WithRetryPrecondition(precondition func(ctx context.Context) bool) func(*Transport) {
+	return func(t *Transport) {
+		t.retryPrecondition = precondition
+	}
+}
+
+// WithShouldRetryFn configures the [ShouldRetryFn] callback to use. This replaces any
+// [ShouldRetryResultFn] configured with [WithShouldRetryResultFn].
 func WithShouldRetryFn(shouldRetryFn ShouldRetryFn) func(*Transport) {
 	return func(t *Transport) {
 		t.shouldRetryFn = shouldRetryFn
+		t.shouldRetryResultFn = nil
+	}
+}
+
+// WithShouldRetryResultFn configures a [ShouldRetryResultFn] to use instead of a
+// [ShouldRetryFn]. Unlike a ShouldRetryFn, it can ask for a retry to be made immediately
+// by setting SkipDelay, in which case the [DelayFn] or [Backoff] isn't consulted for that
+// retry. This suits failures that are known not to need a pause, such as a stale pooled
+// connection that was closed by the server. A [ShouldRetryFn] provided using
+// [SetShouldRetryFn] still takes precedence for that request. Use [AdaptShouldRetryFn] to
+// build on an existing ShouldRetryFn.
+func WithShouldRetryResultFn(shouldRetryResultFn ShouldRetryResultFn) func(*Transport) {
+	return func(t *Transport) {
+		t.shouldRetryResultFn = shouldRetryResultFn
+		t.shouldRetryFn = func(attempt Attempt) bool {
+			return shouldRetryResultFn(attempt).Retry
+		}
+	}
+}
+
+// WithShouldRetryStatusFn configures a [ShouldRetryFn] that only considers the response
+// status code. It is a simpler alternative to [WithShouldRetryFn] for policies that don't
+// care about errors or idempotency; see [StatusShouldRetryFn].
+func WithShouldRetryStatusFn(statusFn func(status int) bool) func(*Transport) {
+	return WithShouldRetryFn(StatusShouldRetryFn(statusFn))
+}
+
+// WithAssumeIdempotent configures whether every request is assumed to be idempotent,
+// regardless of its method or the presence of an idempotency key header. This allows
+// [DefaultShouldRetryFn] and [CustomizedShouldRetryFn] to retry requests like a POST to an
+// upsert endpoint that the caller knows is safe to repeat.
+// Use this with great care: retrying a request that is not actually idempotent can
+// duplicate its side effects, for example creating a resource or charging a payment twice.
+// It is usually better to enable it for individual requests using [SetAssumeIdempotent].
+func WithAssumeIdempotent(assumeIdempotent bool) func(*Transport) {
+	return func(t *Transport) {
+		t.assumeIdempotent = assumeIdempotent
 	}
 }
 
-// WithDelayFn configures the [DelayFn] callback to use.
+// WithDelayFn configures the [DelayFn] callback to use. This replaces any [Backoff]
+// configured with [WithBackoff].
 func WithDelayFn(delayFn DelayFn) func(*Transport) {
 	return func(t *Transport) {
 		t.delayFn = delayFn
+		t.newBackoff = nil
+		t.hostBackoff = nil
+	}
+}
+
+// WithTestMode configures a Transport for use in tests. Every delay between attempts is
+// zero, replacing any configured [DelayFn] or [Backoff], so there is no jitter either. The
+// keys generated by [WithAutoIdempotencyKey] come from a source with a fixed seed instead of
+// crypto/rand, so the same sequence of requests gets the same keys on every run. Together,
+// this makes the behavior of a Transport fast and deterministic, which is useful for tests
+// of code that embeds a retrying client. Options are applied in order, so a later
+// [WithDelayFn], [WithBackoff], or [WithHostBackoff] brings back real delays, as does a
+// [DelayFn] provided using [SetDelayFn] for a single request.
+func WithTestMode() func(*Transport) {
+	delayFn := WithDelayFn(func(_ Attempt) time.Duration {
+		return 0
+	})
+
+	return func(t *Transport) {
+		delayFn(t)
+		t.idempotencyKeyRand = &lockedRand{r: rand.New(rand.NewSource(1))}
+	}
+}
+
+// WithBackoffJitterDisabled configures a Transport to delay exactly as long as
+// [DefaultDelayFn] would at most: a Retry-After header is honored exactly, and otherwise
+// the delay is exponential backoff with base=250ms and cap=10s, without the random jitter.
+// Unlike [WithTestMode], the delays are real. This is useful for reproducible integration
+// tests and other environments where retries must be deterministic. It replaces any
+// configured [DelayFn] or [Backoff]. For other parameters, use [CustomizedDelayFn] with
+// NoJitter set.
+func WithBackoffJitterDisabled() func(*Transport) {
+	return WithDelayFn(CustomizedDelayFn(CustomizedDelayFnOptions{
+		Base:     time.Millisecond * 250,
+		Cap:      time.Second * 10,
+		NoJitter: true,
+	}))
+}
+
+// WithBackoff configures a factory for the stateful [Backoff] to use instead of a
+// [DelayFn]. The factory is called to create a fresh Backoff for each request that needs
+// to delay before a retry. This replaces any [DelayFn] configured with [WithDelayFn],
+// but a [DelayFn] provided using [SetDelayFn] still takes precedence for that request.
+func WithBackoff(newBackoff func() Backoff) func(*Transport) {
+	return func(t *Transport) {
+		t.newBackoff = newBackoff
+		t.delayFn = nil
+		t.hostBackoff = nil
+	}
+}
+
+// WithRetryAfterCap configures a maximum delay to wait when the server includes a valid
+// Retry-After header in its response. Whatever the [DelayFn] (or [Backoff]) returns for
+// such a response is clamped to this value, so it applies equally to [DefaultDelayFn] and
+// to custom delay functions. Delays for responses without a Retry-After header are not
+// affected. A value of 0 means Retry-After-derived delays are not capped.
+func WithRetryAfterCap(retryAfterCap time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.retryAfterCap = retryAfterCap
+	}
+}
+
+// WithMaxTotalDownload configures a limit on the number of response body bytes read across
+// all attempts of a request, for environments where downloads are metered. The count
+// includes the bodies of retried responses, whether they were read by a [ShouldRetryFn],
+// buffered, or drained to reuse the connection (see [WithConnectionReusePolicy]). Once it
+// exceeds n, no further retries are made and the latest response is returned as is. Since
+// a retried body is only drained after the decision to retry, the limit may be exceeded by
+// up to the size of one response body. The default of 0 doesn't limit downloads.
+func WithMaxTotalDownload(n int64) func(*Transport) {
+	return func(t *Transport) {
+		t.maxTotalDownload = n
+	}
+}
+
+// WithCancellationGrace configures a grace period for an attempt that is in flight when the
+// request's context is canceled. Rather than being aborted at once, the attempt may take up
+// to d longer to complete, and if it does its response is returned as is, without any
+// further retries; the response body may be read for the rest of the grace period. Delays
+// between attempts are still interrupted immediately, and no attempt is started once the
+// context is canceled. This trades prompt cancellation, and the resources the attempt holds
+// until it finishes, for not discarding a response that was nearly complete. The default of
+// 0 cancels attempts immediately.
+func WithCancellationGrace(d time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.cancellationGrace = d
+	}
+}
+
+// WithMaxRetryAfter configures the longest Retry-After the Transport is willing to wait
+// for. If a response that would otherwise be retried asks for a longer wait, such as a 503
+// from a service down for a maintenance window hours long, the request fails fast instead:
+// the response is returned to the caller as is, without a retry. Unlike
+// [WithRetryAfterCap], which waits for a shorter time and retries anyway, this leaves it to
+// the caller to decide what to do. It applies to the Retry-After header itself, so it takes
+// precedence over the cap. A value of 0 or less means any Retry-After is waited for. See
+// also [WithOnLongRetryAfter].
+func WithMaxRetryAfter(maxRetryAfter time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.maxRetryAfter = maxRetryAfter
+	}
+}
+
+// WithOnLongRetryAfter configures a callback that is invoked when a retry is given up on
+// because its Retry-After exceeds the limit configured with [WithMaxRetryAfter]. It is
+// called with the attempt and the wait the server asked for, just before the response is
+// returned, which makes it a single place to log or alert on maintenance windows.
+func WithOnLongRetryAfter(onLongRetryAfter func(attempt Attempt, retryAfter time.Duration)) func(*Transport) {
+	return func(t *Transport) {
+		t.onLongRetryAfter = onLongRetryAfter
 	}
 }
 
 // WithPreventRetryWithBody configures whether to prevent retries on requests that
 // have bodies. This may be desirable because any request that has a chance of
 // requiring a retry must have its body buffered into memory by Transport in case
-// it needs to be replayed on subsequent attempts. It is up to package consumers
-// to determine if and when this behavior is appropriate.
+// it needs to be replayed on subsequent attempts. A body that implements [io.Seeker],
+// such as an *os.File, is not buffered but rewound for each attempt instead, and is
+// closed once all attempts are done. It is up to package consumers
+// to determine if and when this behavior is appropriate. An empty body is treated the
+// same as no body at all, so it never prevents retries. A body of unknown length is sent
+// as is rather than read ahead of the request, and is only found to be empty once an
+// attempt has read all of it. Requests whose body can be replayed with GetBody are exempt
+// if [WithAllowRetryWithGetBody] is also used.
 func WithPreventRetryWithBody(preventRetryWithBody bool) func(*Transport) {
 	return func(t *Transport) {
 		t.preventRetryWithBody = preventRetryWithBody
 	}
 }
 
+// WithRefuseBodyBuffering configures whether a Transport refuses to buffer request bodies
+// into memory. By default, a request body without GetBody that can't be rewound using Seek
+// is buffered so that it can be replayed, which can exhaust memory when streaming large or
+// unbounded bodies (for example when proxying). When enabled, such a request fails with
+// [ErrUnbufferableBody] instead of being sent, forcing the caller to make an explicit
+// choice: provide GetBody, in which case the body is replayed using it, or prevent retries
+// for the request with [SetPreventRetryWithBody].
+func WithRefuseBodyBuffering(refuseBodyBuffering bool) func(*Transport) {
+	return func(t *Transport) {
+		t.refuseBuffering = refuseBodyBuffering
+	}
+}
+
+// WithAllowRetryWithGetBody configures whether requests with a GetBody function may still
+// be retried when [WithPreventRetryWithBody] is enabled. Preventing retries with bodies is
+// usually done to avoid buffering request bodies into memory, but a request with GetBody
+// can produce a fresh copy of its body for each attempt without any buffering. When
+// enabled, such requests are retried and their bodies are replayed using GetBody. Requests
+// without GetBody (a raw stream) are still not retried. [http.NewRequest] sets GetBody
+// automatically for *bytes.Buffer, *bytes.Reader, and *strings.Reader bodies.
+func WithAllowRetryWithGetBody(allowRetryWithGetBody bool) func(*Transport) {
+	return func(t *Transport) {
+		t.allowRetryGetBody = allowRetryWithGetBody
+	}
+}
+
+// WithSingleFlight configures whether concurrent identical GET and HEAD requests without a
+// body share a single stream of attempts. Requests are identical if they have the same
+// method, URL, and headers, so requests with different credentials, cookies, ranges, or
+// content negotiation are never shared. Every caller receives its own copy of the response,
+// whose body is read fully into memory before it is shared. The shared attempts carry the
+// values of the first caller's context but not its cancellation or deadline: each caller
+// whose context expires while waiting returns early, and the attempts are only canceled once
+// every caller has given up. Bound them with [WithAttemptTimeout] and [WithMaxRetries]. This
+// reduces the load that retries of a popular resource put on a struggling dependency.
+func WithSingleFlight(singleFlight bool) func(*Transport) {
+	return func(t *Transport) {
+		t.flights = nil
+		if singleFlight {
+			t.flights = &flightGroup{}
+		}
+	}
+}
+
+// WithRecoverPanics configures whether a panic in the internal roundtripper is converted
+// into an error. Regardless of this setting, the attempt's context is canceled and the
+// request body is closed when the internal roundtripper panics, so a misbehaving
+// roundtripper doesn't leak resources. If enabled, the attempt then fails with an error
+// wrapping [ErrRoundTripperPanicked], which is treated like any other attempt error by the
+// [ShouldRetryFn]. Otherwise the panic is propagated to the caller.
+func WithRecoverPanics(recoverPanics bool) func(*Transport) {
+	return func(t *Transport) {
+		t.recoverPanics = recoverPanics
+	}
+}
+
+// WithResponseValidator configures a validator that is run on every successful (2xx)
+// response, for services that occasionally return a success status with a malformed
+// payload, such as truncated JSON or a missing required field. If the validator returns a
+// non-nil error, the request is retried if it is guessed to be idempotent, with the reason
+// [RetryReasonInvalidResponse]. If no retry is made, the response is closed and the
+// validator's error is returned instead. The validator is responsible for restoring any
+// part of the response body it consumes; [PeekResponseBody] does this automatically.
+func WithResponseValidator(validator func(res *http.Response) error) func(*Transport) {
+	return func(t *Transport) {
+		t.responseValidator = validator
+	}
+}
+
+// WithFailoverHosts configures alternate hosts for retries, such as the other regions of a
+// highly available service. Each retry of a request that is guessed to be idempotent is
+// sent to the next host in a rotation that starts with the request's own host, followed by
+// hosts in order. Each host is either a host with an optional port, like
+// "backup.example.com:8443", or includes a scheme, like "https://backup.example.com", to
+// switch the scheme as well. The path and query are kept. Retries of other requests are
+// always sent to the request's own host. The caller's request is not modified.
+func WithFailoverHosts(hosts []string) func(*Transport) {
+	return func(t *Transport) {
+		t.failoverHosts = make([]failoverHost, 0, len(hosts))
+		for _, host := range hosts {
+			var fh failoverHost
+			if i := strings.Index(host, "://"); i >= 0 {
+				fh.scheme, host = host[:i], host[i+len("://"):]
+			}
+			fh.host = host
+			t.failoverHosts = append(t.failoverHosts, fh)
+		}
+	}
+}
+
 // WithAttemptTimeout configures a per-attempt timeout to be used in requests. A
 // per-attempt timeout differs from an overall timeout in that it applies to and is
 // reset in each individual attempt rather than all attempts and delays combined.
@@ -75,6 +540,321 @@ func WithAttemptTimeout(attemptTimeout time.Duration) func(*Transport) {
 	}
 }
 
+// WithAttemptTimeoutFn configures a function that computes the per-attempt timeout for
+// each request, for example based on its host or path. This allows a single Transport to
+// be shared by backends that need different timeouts. It is consulted once per request,
+// and a returned value of 0 means no per-attempt timeout. When set, it takes precedence
+// over [WithAttemptTimeout]; a timeout set using [SetAttemptTimeout] still takes
+// precedence over both.
+func WithAttemptTimeoutFn(attemptTimeoutFn func(req *http.Request) time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.attemptTimeoutFn = attemptTimeoutFn
+	}
+}
+
+// WithSkipDoomedAttempts configures the Transport to skip retries that can't finish before
+// the request context's deadline. A retry is considered doomed if, once the delay before it
+// has elapsed, less time would remain than the attempt timeout (see [WithAttemptTimeout])
+// or minRoundTrip, whichever is larger. Rather than letting such an attempt consume the
+// tail of the deadline only to be cut short, the last response (or error) is returned
+// straight away. minRoundTrip is the shortest time a round trip is expected to take, and
+// may be 0 to rely on the attempt timeout alone. Requests without a deadline are not
+// affected.
+func WithSkipDoomedAttempts(minRoundTrip time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.skipDoomed = true
+		t.minRoundTrip = minRoundTrip
+	}
+}
+
+// WithDeadlineHeader configures the name of a request header, such as X-Deadline, that
+// carries a deadline for the request. No retry is made if its delay would end past that
+// deadline, and the last response (or error) is returned instead. This lets deadlines
+// propagated from upstream services stop retries even where the request context's
+// deadline isn't plumbed through. The header value can be either an absolute deadline in
+// milliseconds since the Unix epoch, or a duration such as "1.5s" relative to when the
+// request was made. Values that can't be parsed are ignored. The header is sent as is.
+func WithDeadlineHeader(name string) func(*Transport) {
+	return func(t *Transport) {
+		t.deadlineHeader = name
+	}
+}
+
+// WithRetryOnTrailer configures a predicate that is consulted with the response's HTTP
+// trailers after each attempt. If it returns true and the request is guessed to be
+// idempotent, the request is retried even if the [ShouldRetryFn] declined to. Some
+// streaming and gRPC-over-HTTP services signal retryability in trailers rather than headers.
+// Trailers are only populated once the response body has been read to EOF, so when this
+// option is set every response body that could be followed by a retry is read fully into
+// memory before the predicate is consulted. This can be expensive for large responses.
+// The buffered body is then returned to the caller as normal if no retry is made.
+func WithRetryOnTrailer(retryOnTrailerFn func(trailer http.Header) bool) func(*Transport) {
+	return func(t *Transport) {
+		t.retryOnTrailerFn = retryOnTrailerFn
+	}
+}
+
+// WithSizeRecorder configures a callback that reports the number of bytes sent and
+// received by each attempt. This is useful for quantifying the bandwidth cost of retries.
+// The number of bytes sent is the length of the request body. The number of bytes
+// received is the number of response body bytes read, so the callback is invoked once the
+// response body is closed: by Transport itself for attempts that are retried, or by the
+// caller for the response that is returned. Attempts that fail without a response are
+// reported immediately with 0 bytes received.
+func WithSizeRecorder(sizeRecorder func(attempt Attempt, sent, received int64)) func(*Transport) {
+	return func(t *Transport) {
+		t.sizeRecorder = sizeRecorder
+	}
+}
+
+// WithBeforeAttempt configures a callback that is invoked immediately before every attempt,
+// including the first, and may modify the request about to be sent. The attempt's Count is
+// the number of the upcoming attempt, starting at 1, and its Res and Err are nil. Its Req is
+// a copy of the caller's request with its own header, so headers can be set on it without
+// affecting other attempts; its body is the body to be sent in this attempt, which a
+// callback that reads it must replace with an equivalent unread one. This is the place to
+// refresh anything that goes stale between attempts, such as a request signature (see
+// providers.ResignOnRetry). If the callback returns an error, the attempt isn't made and the
+// error is returned wrapped. It is invoked before the callback configured with
+// [WithOnAttempt], which therefore sees its changes.
+func WithBeforeAttempt(beforeAttempt func(attempt Attempt) error) func(*Transport) {
+	return func(t *Transport) {
+		t.beforeAttempt = beforeAttempt
+	}
+}
+
+// WithOnAttempt configures a callback that is invoked immediately before every attempt,
+// including the first. The attempt's Count is the number of the upcoming attempt, starting
+// at 1, and its Res and Err are nil. Its Req is the request as it is about to be sent,
+// including any header set by [WithAttemptHeader]. This is a single place to start a
+// latency timer or log each outbound request.
+func WithOnAttempt(onAttempt func(attempt Attempt)) func(*Transport) {
+	return func(t *Transport) {
+		t.onAttempt = onAttempt
+	}
+}
+
+// WithDelayRecorder configures a callback that is invoked with each delay computed by the
+// [DelayFn] (or [Backoff]), along with the attempt it was computed for. The recorded delay
+// is the policy's decision, before it is clamped by [WithRetryAfterCap] or cut short by
+// the request context expiring, so this is useful for asserting on a delay policy in tests
+// without replacing it. A retry whose delay was skipped (see [ShouldRetryResult]) is
+// recorded with a delay of 0.
+func WithDelayRecorder(delayRecorder func(attempt Attempt, delay time.Duration)) func(*Transport) {
+	return func(t *Transport) {
+		t.delayRecorder = delayRecorder
+	}
+}
+
+// WithTimingRecorder configures a callback that is invoked once for each request, when its
+// outcome is about to be returned, with a [TimingReport] breaking down how long was spent
+// making attempts and how long was spent waiting between them. This helps tell how much of
+// a request's latency is due to backoff rather than to the destination.
+func WithTimingRecorder(timingRecorder func(report TimingReport)) func(*Transport) {
+	return func(t *Transport) {
+		t.timingRecorder = timingRecorder
+	}
+}
+
+// WithRetryAfterParser configures a custom parser for the value of the Retry-After header,
+// for servers that deviate from the standard formats, for example by quoting the value or
+// sending a comma-separated list. It is tried before the standard parsing of an integer
+// number of seconds or an HTTP date, which is used if it returns false. The parser is
+// consulted by [DefaultDelayFn], [CustomizedDelayFn], [WithRetryAfterCap], and any custom
+// [DelayFn] that uses [Attempt.RetryAfter].
+func WithRetryAfterParser(parser func(value string) (time.Duration, bool)) func(*Transport) {
+	return func(t *Transport) {
+		t.retryAfterParser = parser
+	}
+}
+
+// WithRedactedHeaders configures the headers whose values are redacted when an [Attempt]
+// created by the Transport is serialized with [Attempt.MarshalJSON] or logged with
+// Attempt.LogValue, replacing [DefaultRedactedHeaders]. Names are matched
+// case-insensitively. Include the defaults to add to them rather than replace them.
+func WithRedactedHeaders(headers ...string) func(*Transport) {
+	return func(t *Transport) {
+		t.redactedHeaders = append([]string{}, headers...)
+	}
+}
+
+// WithRetryAfterParseErrorRecorder configures a callback that is invoked when a retry is
+// about to be made after a response whose Retry-After header is malformed. The delay still
+// falls back to the [DelayFn]'s usual backoff, as it does without the callback; this only
+// makes the fallback visible, so that upstreams sending bad headers can be detected. It is
+// called before the delay is computed.
+func WithRetryAfterParseErrorRecorder(recorder func(attempt Attempt, err *RetryAfterParseError)) func(*Transport) {
+	return func(t *Transport) {
+		t.retryAfterRecorder = recorder
+	}
+}
+
+// WithConnTraceRecorder configures a callback that reports how the connection for each
+// attempt was obtained: whether an existing connection was reused, and how long DNS
+// resolution, dialing, and the TLS handshake took for a new one. This helps diagnose
+// whether failures that lead to retries are related to cold connections. It is invoked
+// once each attempt's round trip returns. Connection events are collected using
+// [net/http/httptrace], alongside any trace already attached to the request context, and
+// only if this option is set, so there is no overhead otherwise. It relies on the internal
+// roundtripper supporting httptrace, as [http.Transport] does. The attempt's Req is the
+// request as it was sent, including the host it was sent to with [WithFailoverHosts].
+func WithConnTraceRecorder(connTraceRecorder func(attempt Attempt, trace ConnTrace)) func(*Transport) {
+	return func(t *Transport) {
+		t.connTraceRecorder = connTraceRecorder
+	}
+}
+
+// WithAutoIdempotencyKey configures the name of a request header, such as
+// Idempotency-Key, that is set to a random UUID for requests that use a non-idempotent
+// method (like POST) and don't already have it. The key is generated once per request and
+// sent unchanged on every attempt, so a backend that honors it can safely deduplicate
+// retries. With the header names Idempotency-Key or X-Idempotency-Key, the request is also
+// guessed to be idempotent by [DefaultShouldRetryFn], which allows it to be retried. The
+// caller's request is not modified.
+func WithAutoIdempotencyKey(header string) func(*Transport) {
+	return func(t *Transport) {
+		t.autoIdempotencyKey = header
+	}
+}
+
+// WithAttemptHeader configures the name of a request header that is set to the attempt
+// number on each outgoing attempt, starting at 1 for the initial attempt. This lets servers
+// and logs correlate retries of the same request, for example alongside a stable
+// X-Request-Id header set by the caller. The caller's request is not modified.
+func WithAttemptHeader(name string) func(*Transport) {
+	return func(t *Transport) {
+		t.attemptHeader = name
+	}
+}
+
+// WithResponseRetryHeader configures the name of a response header, such as X-Max-Retries,
+// with which the server can limit the number of further retries of a request, for example
+// to tell clients to stop retrying while it is under stress. Its value is the number of
+// retries the server still allows after the response it is on, so 0 stops retrying
+// immediately. It can only lower the number of retries, never raise it above the configured
+// maximum or a lower limit set by an earlier response. A value that isn't a non-negative
+// integer is ignored.
+func WithResponseRetryHeader(name string) func(*Transport) {
+	return func(t *Transport) {
+		t.responseRetryHeader = name
+	}
+}
+
+// WithResponseTransformer configures a function that is called once with the final
+// response, whether it succeeded or retries were given up on, before it is returned to the
+// caller. It is not called for responses that are retried, or if the final attempt returned
+// no response. It can be used for example to stamp attempt metadata, unwrap an envelope
+// format, or normalize errors. The transformer takes ownership of the response: whatever it
+// returns is returned to the caller in its place, and if it replaces the body, it is
+// responsible for closing the original one. If it returns an error, that error is returned
+// instead of the attempt's.
+func WithResponseTransformer(transformer func(res *http.Response) (*http.Response, error)) func(*Transport) {
+	return func(t *Transport) {
+		t.responseTransformer = transformer
+	}
+}
+
+// WithBufferResponseOn configures a predicate on the status of each attempt's response that
+// decides whether its body is read into memory as soon as it arrives, before the
+// [ShouldRetryFn] and the validator configured with [WithResponseValidator] are called. The
+// body of a buffered response can then be read in full by either of them, for example to
+// match its content, and is restored to its beginning before it is returned to the caller.
+// Selecting only the statuses a body-based decision applies to, such as 5xx, avoids
+// buffering large successful payloads, which are streamed to the caller directly. If
+// reading the body fails, the attempt fails with that error instead.
+func WithBufferResponseOn(predicate func(status int) bool) func(*Transport) {
+	return func(t *Transport) {
+		t.bufferResponseOn = predicate
+	}
+}
+
+// WithExposeAttemptHeader configures the name of a response header that is set to the
+// number of attempts made, on whichever response is returned, whether it succeeded or not.
+// Without it, a response that succeeded after retries is indistinguishable from one that
+// succeeded on the first attempt, which makes end-to-end debugging and client-side metrics
+// harder. If name is empty, [DefaultExposeAttemptHeader] is used.
+func WithExposeAttemptHeader(name string) func(*Transport) {
+	return func(t *Transport) {
+		if name == "" {
+			name = DefaultExposeAttemptHeader
+		}
+		t.exposeAttemptHeader = name
+	}
+}
+
+// WithRetriesExhaustedError configures whether a [*RetriesExhaustedError] is returned when
+// the last attempt allowed by the maximum number of retries would otherwise have been
+// retried, such as a persistent 503, so that callers can tell retries were exhausted from
+// the error alone. The last response is still returned alongside the error. Since this
+// requires consulting the [ShouldRetryFn] for the last attempt, which is otherwise skipped,
+// it is disabled by default.
+func WithRetriesExhaustedError(retriesExhaustedErr bool) func(*Transport) {
+	return func(t *Transport) {
+		t.retriesExhaustedErr = retriesExhaustedErr
+	}
+}
+
+// WithAddressRotation configures whether a retry after a failure to connect moves on to
+// another of the host's resolved addresses, so that a single unhealthy instance behind a
+// name with several addresses doesn't fail every attempt. It only applies to requests that
+// are guessed to be idempotent (see [Attempt.GuessIdempotent]), and requires the internal
+// roundtripper to dial using a [RotatingDialer], which picks the address; with any other
+// dialer it has no effect. The [ShouldRetryFn] still decides whether to retry such errors.
+func WithAddressRotation(addressRotation bool) func(*Transport) {
+	return func(t *Transport) {
+		t.addressRotation = addressRotation
+	}
+}
+
+// WithAttemptCountContext configures whether the attempt number is stored in the context
+// of each attempt's request, starting at 1 for the initial attempt. The internal
+// roundtripper, and any middleware or tracing it uses, can then read it using
+// [AttemptCountFromContext]. The caller's request is not modified.
+func WithAttemptCountContext(attemptCountContext bool) func(*Transport) {
+	return func(t *Transport) {
+		t.attemptCountContext = attemptCountContext
+	}
+}
+
+// WithConnectionReusePolicy configures what happens to the response body of an attempt
+// that is going to be retried. By default ([ConnectionReuseDrain]) the body is read to the
+// end before it is closed so that the keep-alive connection can be reused for the next
+// attempt. With [ConnectionReuseClose] the body is closed without being read, which avoids
+// the cost of draining it at the expense of the connection, so the next attempt may have
+// to dial a new one. This is appropriate for clients that don't benefit from keep-alive.
+// Responses that are returned to the caller are never affected.
+func WithConnectionReusePolicy(policy ConnectionReusePolicy) func(*Transport) {
+	return func(t *Transport) {
+		t.connReusePolicy = policy
+	}
+}
+
+// WithDisableKeepAliveDrain configures whether the response body of an attempt that is
+// going to be retried is closed immediately instead of being drained. It is shorthand for
+// [WithConnectionReusePolicy] with [ConnectionReuseClose] (or [ConnectionReuseDrain] if
+// false), for latency-sensitive callers that would rather start the retry at once than wait
+// to read a large or slow error body to keep the connection.
+func WithDisableKeepAliveDrain(disable bool) func(*Transport) {
+	if disable {
+		return WithConnectionReusePolicy(ConnectionReuseClose)
+	}
+	return WithConnectionReusePolicy(ConnectionReuseDrain)
+}
+
+// SetDisabled can be used to override the settings on a Transport.
+// Any request made with the returned context will have its Disabled setting overridden
+// with the provided value.
+func SetDisabled(ctx context.Context, disabled bool) context.Context {
+	return context.WithValue(ctx, disabledContextKey, disabled)
+}
+
+// SetAssumeIdempotent can be used to override the settings on a Transport.
+// Any request made with the returned context will have its AssumeIdempotent setting
+// overridden with the provided value. See [WithAssumeIdempotent] for the risks involved.
+func SetAssumeIdempotent(ctx context.Context, assumeIdempotent bool) context.Context {
+	return context.WithValue(ctx, assumeIdempotentContextKey, assumeIdempotent)
+}
+
 // SetMaxRetries can be used to override the settings on a Transport.
 // Any request made with the returned context will have its MaxRetries setting
 // overridden with the provided value.
@@ -82,6 +862,13 @@ func SetMaxRetries(ctx context.Context, maxRetries int) context.Context {
 	return context.WithValue(ctx, maxRetriesContextKey, maxRetries)
 }
 
+// SetMaxRetriesPerStatus can be used to override the settings on a Transport.
+// Any request made with the returned context will have its MaxRetriesPerStatus setting
+// overridden with the provided value.
+func SetMaxRetriesPerStatus(ctx context.Context, maxRetriesPerStatus map[int]int) context.Context {
+	return context.WithValue(ctx, maxRetriesPerStatusContextKey, maxRetriesPerStatus)
+}
+
 // SetShouldRetryFn can be used to override the settings on a Transport.
 // Any request made with the returned context will have its [ShouldRetryFn] overridden with
 // the provided value.
@@ -96,13 +883,46 @@ func SetDelayFn(ctx context.Context, delayFn DelayFn) context.Context {
 	return context.WithValue(ctx, delayFnContextKey, delayFn)
 }
 
+// SetRetryAfterCap can be used to override the settings on a Transport.
+// Any request made with the returned context will have its RetryAfterCap setting
+// overridden with the provided value.
+func SetRetryAfterCap(ctx context.Context, retryAfterCap time.Duration) context.Context {
+	return context.WithValue(ctx, retryAfterCapContextKey, retryAfterCap)
+}
+
 // SetPreventRetryWithBody can be used to override the settings on a
 // Transport. Any request made with the returned context will have its
 // PreventRetryWithbody setting overridden with the provided value.
+// Overriding it to false makes the request's body replayable as if the Transport never
+// prevented retries: a seekable body is rewound, and any other body is buffered into
+// memory, even if the request has no GetBody, unless buffering is refused with
+// [WithRefuseBodyBuffering], in which case GetBody is used if it is set. Overriding it to
+// true prevents retries of the request unless GetBody is set and
+// [WithAllowRetryWithGetBody] is used.
 func SetPreventRetryWithBody(ctx context.Context, preventRetryWithBody bool) context.Context {
 	return context.WithValue(ctx, preventRetryWithBodyContextKey, preventRetryWithBody)
 }
 
+// SetRetryGroup returns a context that makes any request made with it a member of group, so
+// that it stops retrying once any other member succeeds. See [RetryGroup].
+func SetRetryGroup(ctx context.Context, group *RetryGroup) context.Context {
+	return context.WithValue(ctx, retryGroupContextKey, group)
+}
+
+// SetRefuseBodyBuffering can be used to override the settings on a Transport.
+// Any request made with the returned context will have its RefuseBodyBuffering setting
+// overridden with the provided value.
+func SetRefuseBodyBuffering(ctx context.Context, refuseBodyBuffering bool) context.Context {
+	return context.WithValue(ctx, refuseBodyBufferingContextKey, refuseBodyBuffering)
+}
+
+// SetAllowRetryWithGetBody can be used to override the settings on a Transport.
+// Any request made with the returned context will have its AllowRetryWithGetBody setting
+// overridden with the provided value.
+func SetAllowRetryWithGetBody(ctx context.Context, allowRetryWithGetBody bool) context.Context {
+	return context.WithValue(ctx, allowRetryWithGetBodyContextKey, allowRetryWithGetBody)
+}
+
 // SetAttemptTimeout can be used to override the settings on a// Transport.
 // Any request made with the returned context will have its AttemptTimeout setting
 // overridden with the provided value.
@@ -110,11 +930,30 @@ func SetAttemptTimeout(ctx context.Context, attemptTimeout time.Duration) contex
 	return context.WithValue(ctx, attemptTimeoutContextKey, attemptTimeout)
 }
 
+// SetRetryOnTrailer can be used to override the settings on a Transport.
+// Any request made with the returned context will have its trailer predicate overridden with
+// the provided value. Providing nil disables trailer inspection for the request.
+func SetRetryOnTrailer(ctx context.Context, retryOnTrailerFn func(trailer http.Header) bool) context.Context {
+	return context.WithValue(ctx, retryOnTrailerFnContextKey, retryOnTrailerFn)
+}
+
+// SetConnectionReusePolicy can be used to override the settings on a Transport.
+// Any request made with the returned context will have its ConnectionReusePolicy setting
+// overridden with the provided value.
+func SetConnectionReusePolicy(ctx context.Context, policy ConnectionReusePolicy) context.Context {
+	return context.WithValue(ctx, connReusePolicyContextKey, policy)
+}
+
 func getMaxRetriesFromContext(ctx context.Context) (int, bool) {
 	val, ok := ctx.Value(maxRetriesContextKey).(int)
 	return val, ok
 }
 
+func getMaxRetriesPerStatusFromContext(ctx context.Context) (map[int]int, bool) {
+	val, ok := ctx.Value(maxRetriesPerStatusContextKey).(map[int]int)
+	return val, ok
+}
+
 func getShouldRetryFnFromContext(ctx context.Context) (ShouldRetryFn, bool) {
 	val, ok := ctx.Value(shouldRetryFnContextKey).(ShouldRetryFn)
 	return val, ok
@@ -130,7 +969,56 @@ func getPreventRetryWithBodyFromContext(ctx context.Context) (bool, bool) {
 	return val, ok
 }
 
+func getAllowRetryWithGetBodyFromContext(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(allowRetryWithGetBodyContextKey).(bool)
+	return val, ok
+}
+
 func getAttemptTimeoutFromContext(ctx context.Context) (time.Duration, bool) {
 	val, ok := ctx.Value(attemptTimeoutContextKey).(time.Duration)
 	return val, ok
 }
+
+func getRetryOnTrailerFnFromContext(ctx context.Context) (func(trailer http.Header) bool, bool) {
+	val, ok := ctx.Value(retryOnTrailerFnContextKey).(func(trailer http.Header) bool)
+	return val, ok
+}
+
+func getRetryAfterCapFromContext(ctx context.Context) (time.Duration, bool) {
+	val, ok := ctx.Value(retryAfterCapContextKey).(time.Duration)
+	return val, ok
+}
+
+func getConnectionReusePolicyFromContext(ctx context.Context) (ConnectionReusePolicy, bool) {
+	val, ok := ctx.Value(connReusePolicyContextKey).(ConnectionReusePolicy)
+	return val, ok
+}
+
+// AttemptCountFromContext returns the attempt number stored in ctx by a Transport
+// configured with [WithAttemptCountContext], and whether one was found. It is intended for
+// use by the internal roundtripper, which receives a request with such a context for each
+// attempt.
+func AttemptCountFromContext(ctx context.Context) (int, bool) {
+	val, ok := ctx.Value(attemptCountContextKey).(int)
+	return val, ok
+}
+
+func getDisabledFromContext(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(disabledContextKey).(bool)
+	return val, ok
+}
+
+func getAssumeIdempotentFromContext(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(assumeIdempotentContextKey).(bool)
+	return val, ok
+}
+
+func getRetryGroupFromContext(ctx context.Context) *RetryGroup {
+	val, _ := ctx.Value(retryGroupContextKey).(*RetryGroup)
+	return val
+}
+
+func getRefuseBodyBufferingFromContext(ctx context.Context) (bool, bool) {
+	val, ok := ctx.Value(refuseBodyBufferingContextKey).(bool)
+	return val, ok
+}
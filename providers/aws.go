@@ -0,0 +1,234 @@
+// Package providers contains retry policies tuned for the conventions of specific
+// third-party services. They are kept out of the retryhttp package so that its core stays
+// generic.
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+// RetryReasonAWSThrottling signals a retry due to an AWS throttling error code.
+const RetryReasonAWSThrottling retryhttp.RetryReason = "aws-throttling"
+
+// awsPeekSize is how much of an error response body is inspected for an AWS error code.
+// AWS error payloads are small, so the code is expected well within this limit.
+const awsPeekSize = 16 * 1024
+
+// awsThrottlingCodes are the error codes AWS services use to signal throttling. They may
+// accompany a 400 or a 5xx status, so the status alone isn't enough to identify them.
+var awsThrottlingCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottledException":              true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"TransactionInProgressException":         true,
+	"RequestLimitExceeded":                   true,
+	"BandwidthLimitExceeded":                 true,
+	"LimitExceededException":                 true,
+	"RequestThrottled":                       true,
+	"SlowDown":                               true,
+	"PriorRequestNotComplete":                true,
+	"EC2ThrottledException":                  true,
+}
+
+var awsShouldRetryFn = retryhttp.CustomizedShouldRetryFn(retryhttp.CustomizedShouldRetryFnOptions{
+	IdempotentMethods: []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete,
+	},
+	RetryableStatusCodes: []int{
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+})
+
+var (
+	awsThrottlingDelayFn = retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+		Base:            time.Millisecond * 500,
+		Cap:             time.Second * 20,
+		JitterMagnitude: 0.333,
+	})
+	awsDelayFn = retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+		Base:            time.Millisecond * 100,
+		Cap:             time.Second * 20,
+		JitterMagnitude: 0.333,
+	})
+)
+
+// AWSRetryPolicy returns a [retryhttp.ShouldRetryFn] and [retryhttp.DelayFn] pair suited
+// to AWS service APIs. AWS signals throttling with error codes such as ThrottlingException
+// or ProvisionedThroughputExceededException in the response body, with a variety of 400
+// and 5xx statuses. The ShouldRetryFn recognizes these codes (in the X-Amzn-ErrorType
+// header, or the __type or code field of a JSON body or the Code element of an XML body of
+// up to 16KiB, which is restored afterwards) and retries them regardless of method, since
+// throttled requests were not processed. Codes are compared exactly, ignoring any
+// namespace prefix. Otherwise it behaves like [retryhttp.DefaultShouldRetryFn],
+// additionally treating 500 and 504 as retryable. The DelayFn backs off from a larger base
+// (500ms) for throttling than for other failures (100ms), both capped at 20s, and respects
+// the Retry-After header.
+func AWSRetryPolicy() (retryhttp.ShouldRetryFn, retryhttp.DelayFn) {
+	shouldRetry := func(attempt retryhttp.Attempt) bool {
+		if attempt.Res != nil && isAWSThrottling(attempt.Res) {
+			attempt.ReportReason(RetryReasonAWSThrottling)
+			return true
+		}
+
+		return awsShouldRetryFn(attempt)
+	}
+
+	delay := func(attempt retryhttp.Attempt) time.Duration {
+		if attempt.Reason == RetryReasonAWSThrottling {
+			return awsThrottlingDelayFn(attempt)
+		}
+
+		return awsDelayFn(attempt)
+	}
+
+	return shouldRetry, delay
+}
+
+func isAWSThrottling(res *http.Response) bool {
+	if res.StatusCode < 400 {
+		return false
+	}
+
+	if errType := res.Header.Get("X-Amzn-ErrorType"); errType != "" {
+		return awsThrottlingCodes[awsErrorCode(errType)]
+	}
+
+	body, err := retryhttp.PeekResponseBody(res, awsPeekSize)
+	if err != nil {
+		return false
+	}
+
+	return awsThrottlingCodes[awsErrorCode(parseAWSErrorCode(body))]
+}
+
+// parseAWSErrorCode returns the error code of an AWS error payload: the __type or code
+// field of a JSON body, or the first Code element of an XML body. It returns an empty
+// string if the body isn't an error payload in either format.
+func parseAWSErrorCode(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+
+	if body[0] == '{' {
+		var payload struct {
+			Type string `json:"__type"`
+			Code string `json:"code"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ""
+		}
+		if payload.Type != "" {
+			return payload.Type
+		}
+		return payload.Code
+	}
+
+	if body[0] == '<' {
+		dec := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return ""
+			}
+			if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "Code" {
+				var code string
+				if err := dec.DecodeElement(&code, &start); err != nil {
+					return ""
+				}
+				return strings.TrimSpace(code)
+			}
+		}
+	}
+
+	return ""
+}
+
+// awsErrorCode strips the namespace prefix and URI suffix that some AWS protocols add to an
+// error code, as in "com.amazonaws.dynamodb.v20120810#ThrottlingException" or
+// "ThrottlingException:http://internal.amazon.com/coral/com.amazon.coral.availability/".
+func awsErrorCode(code string) string {
+	if i := strings.IndexByte(code, ':'); i >= 0 {
+		code = code[:i]
+	}
+	if i := strings.LastIndexByte(code, '#'); i >= 0 {
+		code = code[i+1:]
+	}
+	return strings.TrimSpace(code)
+}
+
+// ResignOnRetry returns an option that re-signs a request before every retry, using
+// [retryhttp.WithBeforeAttempt]. Signatures such as AWS Signature Version 4 include a
+// timestamp and expire after a few minutes, so a retry made after a long delay needs a fresh
+// one. The initial attempt is sent as signed by the caller.
+//
+// signer is called with the request about to be sent, and should sign it the same way the
+// caller signed the original, typically by setting the Authorization and X-Amz-Date
+// headers. Any headers it sets only apply to that attempt. The request's body is the full
+// body being replayed, and signer may read it to compute a payload hash: it is buffered for
+// the duration of the call and restored afterwards. If signer returns an error, the retry
+// isn't made and the error is returned.
+//
+// For example, with the signer of the AWS SDK for Go v2:
+//
+//	retryhttp.New(providers.ResignOnRetry(func(req *http.Request) error {
+//		body, err := io.ReadAll(req.Body)
+//		if err != nil {
+//			return err
+//		}
+//		hash := sha256.Sum256(body)
+//		creds, err := credsProvider.Retrieve(req.Context())
+//		if err != nil {
+//			return err
+//		}
+//		return signer.SignHTTP(req.Context(), creds, req, hex.EncodeToString(hash[:]), "execute-api", region, time.Now())
+//	}))
+func ResignOnRetry(signer func(*http.Request) error) func(*retryhttp.Transport) {
+	return retryhttp.WithBeforeAttempt(func(attempt retryhttp.Attempt) error {
+		if attempt.Count == 1 {
+			return nil
+		}
+
+		req := attempt.Req
+		var body []byte
+		hasBody := req.Body != nil && req.Body != http.NoBody
+		if hasBody {
+			var err error
+			body, err = io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return fmt.Errorf("error reading body to re-sign: %w", err)
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if err := signer(req); err != nil {
+			return err
+		}
+
+		if hasBody {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,273 @@
+package providers_test
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+	"github.com/justinrixx/retryhttp/providers"
+)
+
+func TestAWSRetryPolicyShouldRetry(t *testing.T) {
+	shouldRetry, _ := providers.AWSRetryPolicy()
+
+	tests := []struct {
+		name   string
+		method string
+		status int
+		header http.Header
+		body   string
+		want   bool
+	}{
+		{
+			name:   "should retry DynamoDB provisioned throughput exceeded for a POST",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			body:   `{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException","message":"The level of configured provisioned throughput for the table was exceeded."}`,
+			want:   true,
+		},
+		{
+			name:   "should retry a JSON ThrottlingException",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			body:   `{"__type":"ThrottlingException","message":"Rate exceeded"}`,
+			want:   true,
+		},
+		{
+			name:   "should retry an S3 SlowDown XML error",
+			method: http.MethodPut,
+			status: http.StatusServiceUnavailable,
+			body:   `<?xml version="1.0" encoding="UTF-8"?><Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`,
+			want:   true,
+		},
+		{
+			name:   "should retry a throttling error type header",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			header: http.Header{"X-Amzn-Errortype": []string{"ThrottlingException:http://internal.amazon.com/coral/com.amazon.coral.availability/"}},
+			want:   true,
+		},
+		{
+			name:   "should retry a JSON throttling code field",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			body:   `{"code":"TooManyRequestsException","message":"Too many requests"}`,
+			want:   true,
+		},
+		{
+			name:   "should retry an EC2 XML throttling error",
+			method: http.MethodPost,
+			status: http.StatusServiceUnavailable,
+			body:   `<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors><RequestID>abc</RequestID></Response>`,
+			want:   true,
+		},
+		{
+			name:   "should not retry a JSON error that mentions throttling in its message",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			body:   `{"__type":"com.amazon.coral.validate#ValidationException","message":"Throttling settings are invalid"}`,
+			want:   false,
+		},
+		{
+			name:   "should not retry a JSON error whose code only contains a throttling code",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			body:   `{"__type":"ThrottlingConfigurationException","message":"Invalid configuration"}`,
+			want:   false,
+		},
+		{
+			name:   "should not retry an XML error that mentions a throttling code in its message",
+			method: http.MethodPut,
+			status: http.StatusBadRequest,
+			body:   `<Error><Code>InvalidArgument</Code><Message>SlowDown is not a valid storage class</Message></Error>`,
+			want:   false,
+		},
+		{
+			name:   "should not retry an error type header that only contains a throttling code",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			header: http.Header{"X-Amzn-Errortype": []string{"ThrottlingConfigurationException:http://internal.amazon.com/coral/com.amazon.coral.validate/"}},
+			want:   false,
+		},
+		{
+			name:   "should not retry a validation error",
+			method: http.MethodPost,
+			status: http.StatusBadRequest,
+			body:   `{"__type":"com.amazon.coral.validate#ValidationException","message":"1 validation error detected"}`,
+			want:   false,
+		},
+		{
+			name:   "should retry an internal error for an idempotent request",
+			method: http.MethodGet,
+			status: http.StatusInternalServerError,
+			body:   `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"Internal server error"}`,
+			want:   true,
+		},
+		{
+			name:   "should not retry an internal error for a non-idempotent request",
+			method: http.MethodPost,
+			status: http.StatusInternalServerError,
+			body:   `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"Internal server error"}`,
+			want:   false,
+		},
+		{
+			name:   "should not retry success",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			body:   `{"Item":{"Throttling":{"S":"not an error"}}}`,
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			res := &http.Response{
+				StatusCode: tt.status,
+				Header:     header,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+
+			actual := shouldRetry(retryhttp.Attempt{
+				Count: 1,
+				Req:   &http.Request{Method: tt.method, Header: http.Header{}},
+				Res:   res,
+			})
+			if actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading body: %s", err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body was not restored: got %s, want %s", string(body), tt.body)
+			}
+		})
+	}
+}
+
+func TestAWSRetryPolicyDelay(t *testing.T) {
+	_, delay := providers.AWSRetryPolicy()
+
+	res := &http.Response{Header: http.Header{}}
+	for i := 0; i < 100; i++ {
+		throttled := delay(retryhttp.Attempt{Count: 1, Res: res, Reason: providers.RetryReasonAWSThrottling})
+		if throttled < 0 || throttled > time.Millisecond*500 {
+			t.Fatalf("throttling delay out of range: got %s, want between 0 and %s", throttled, time.Millisecond*500)
+		}
+
+		other := delay(retryhttp.Attempt{Count: 1, Res: res, Reason: "status-503"})
+		if other < 0 || other > time.Millisecond*100 {
+			t.Fatalf("delay out of range: got %s, want between 0 and %s", other, time.Millisecond*100)
+		}
+
+		capped := delay(retryhttp.Attempt{Count: 100, Res: res, Reason: providers.RetryReasonAWSThrottling})
+		if capped > time.Second*20 {
+			t.Fatalf("throttling delay exceeds cap: got %s, want at most %s", capped, time.Second*20)
+		}
+	}
+}
+
+func TestAWSRetryPolicyTransport(t *testing.T) {
+	attemptCount := 0
+	shouldRetry, _ := providers.AWSRetryPolicy()
+	tr := retryhttp.New(
+		retryhttp.WithShouldRetryFn(shouldRetry),
+		retryhttp.WithDelayFn(func(_ retryhttp.Attempt) time.Duration {
+			return 0
+		}),
+		retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			attemptCount++
+			if attemptCount < 3 {
+				return &http.Response{
+					StatusCode: http.StatusBadRequest,
+					Header:     http.Header{},
+					Body:       io.NopCloser(strings.NewReader(`{"__type":"ThrottlingException","message":"Rate exceeded"}`)),
+				}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		})),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, "https://dynamodb.us-east-1.amazonaws.com", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected nil error but got %s", err)
+	}
+	res.Body.Close()
+	if attemptCount != 3 {
+		t.Fatalf("attempt count does not match expected; got %d, want %d", attemptCount, 3)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code; got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestResignOnRetry(t *testing.T) {
+	var signedBodies []string
+	var received []string
+	tr := retryhttp.New(
+		retryhttp.WithTestMode(),
+		retryhttp.WithMaxRetries(2),
+		providers.ResignOnRetry(func(req *http.Request) error {
+			// like a SigV4 signer, hash the payload
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			signedBodies = append(signedBodies, string(body))
+			req.Header.Set("Authorization", "signed "+strconv.Itoa(len(signedBodies)))
+			return nil
+		}),
+		retryhttp.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			received = append(received, req.Header.Get("Authorization")+": "+string(body))
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: http.NoBody}, nil
+		})),
+	)
+
+	req, err := http.NewRequest(http.MethodPut, "http://example.com", strings.NewReader(`{"key":"value"}`))
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	req.Header.Set("Authorization", "signed 0")
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected nil error but got %s", err)
+	}
+	res.Body.Close()
+
+	// the initial attempt keeps the caller's signature
+	if want := []string{`{"key":"value"}`, `{"key":"value"}`}; !reflect.DeepEqual(signedBodies, want) {
+		t.Errorf("unexpected signed bodies: got %v, want %v", signedBodies, want)
+	}
+	want := []string{
+		`signed 0: {"key":"value"}`,
+		`signed 1: {"key":"value"}`,
+		`signed 2: {"key":"value"}`,
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("unexpected requests: got %v, want %v", received, want)
+	}
+}
@@ -0,0 +1,96 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+// grpcPeekSize is how much of a Connect error response body is inspected for its code.
+const grpcPeekSize = 16 * 1024
+
+// grpcRetryableCodes are the gRPC status codes that signal a transient failure.
+// https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+var grpcRetryableCodes = map[int]bool{
+	8:  true, // RESOURCE_EXHAUSTED
+	14: true, // UNAVAILABLE
+}
+
+// connectCodes maps the codes used in Connect error bodies to their gRPC status codes. Only
+// the codes that matter for retries are needed; any other code is a non-retryable error.
+var connectCodes = map[string]int{
+	"resource_exhausted": 8,
+	"unavailable":        14,
+}
+
+// GRPCStatusRetryReason returns the [retryhttp.RetryReason] for a retry due to a gRPC status
+// code, for example "grpc-status-14".
+func GRPCStatusRetryReason(code int) retryhttp.RetryReason {
+	return retryhttp.RetryReason("grpc-status-" + strconv.Itoa(code))
+}
+
+// GRPCWebShouldRetryFn returns a [retryhttp.ShouldRetryFn] suited to gRPC-Web and Connect
+// services, which report errors using a gRPC status rather than (or in addition to) the
+// HTTP status, often on a 200 response. The status is read from the Grpc-Status header,
+// from the Grpc-Status trailer of a body of up to 16KiB, or from the code in a Connect
+// JSON error body (the first 16KiB of which is inspected). A body that is read is restored
+// afterwards. UNAVAILABLE (14) and RESOURCE_EXHAUSTED (8) are retried if the request is
+// guessed idempotent (see [retryhttp.Attempt.GuessIdempotent]), which for these protocols
+// usually means a Connect GET request or an idempotency key header; any other gRPC status
+// is not retried. Responses without a gRPC status are handled by
+// [retryhttp.DefaultShouldRetryFn].
+func GRPCWebShouldRetryFn() retryhttp.ShouldRetryFn {
+	return func(attempt retryhttp.Attempt) bool {
+		if attempt.Res != nil {
+			if code, ok := grpcStatus(attempt.Res); ok {
+				if grpcRetryableCodes[code] && attempt.GuessIdempotent() {
+					attempt.ReportReason(GRPCStatusRetryReason(code))
+					return true
+				}
+				return false
+			}
+		}
+
+		return retryhttp.DefaultShouldRetryFn(attempt)
+	}
+}
+
+// grpcStatus returns the gRPC status code of a response, and whether it has one at all.
+// Codes from Connect error bodies that aren't relevant to retries are reported as -1.
+func grpcStatus(res *http.Response) (int, bool) {
+	status := res.Header.Get("Grpc-Status")
+	if status == "" && (res.Trailer != nil || strings.HasPrefix(res.Header.Get("Content-Type"), "application/grpc")) {
+		// trailers are only filled in once the body has been read to the end, so a short
+		// body is read first; a status in the trailer of a longer one isn't seen
+		if _, err := retryhttp.PeekResponseBody(res, grpcPeekSize); err != nil {
+			return 0, false
+		}
+		status = res.Trailer.Get("Grpc-Status")
+	}
+	if status != "" {
+		code, err := strconv.Atoi(status)
+		return code, err == nil
+	}
+
+	if res.StatusCode == http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		return 0, false
+	}
+	body, err := retryhttp.PeekResponseBody(res, grpcPeekSize)
+	if err != nil {
+		return 0, false
+	}
+	var connectErr struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(body, &connectErr); err != nil || connectErr.Code == "" {
+		return 0, false
+	}
+	if code, ok := connectCodes[connectErr.Code]; ok {
+		return code, true
+	}
+
+	return -1, true
+}
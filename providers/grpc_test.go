@@ -0,0 +1,231 @@
+package providers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+	"github.com/justinrixx/retryhttp/providers"
+)
+
+func TestGRPCWebShouldRetryFn(t *testing.T) {
+	shouldRetry := providers.GRPCWebShouldRetryFn()
+
+	tests := []struct {
+		name       string
+		method     string
+		reqHeader  http.Header
+		status     int
+		header     http.Header
+		body       string
+		want       bool
+		wantReason retryhttp.RetryReason
+	}{
+		{
+			name:       "should retry UNAVAILABLE in the header of a 200",
+			method:     http.MethodGet,
+			status:     http.StatusOK,
+			header:     http.Header{"Grpc-Status": []string{"14"}, "Grpc-Message": []string{"upstream connect error"}},
+			want:       true,
+			wantReason: providers.GRPCStatusRetryReason(14),
+		},
+		{
+			name:       "should retry UNAVAILABLE for a POST with an idempotency key",
+			method:     http.MethodPost,
+			reqHeader:  http.Header{"Idempotency-Key": []string{"abc"}},
+			status:     http.StatusOK,
+			header:     http.Header{"Grpc-Status": []string{"14"}},
+			want:       true,
+			wantReason: providers.GRPCStatusRetryReason(14),
+		},
+		{
+			name:   "should not retry UNAVAILABLE for a POST without an idempotency key",
+			method: http.MethodPost,
+			status: http.StatusOK,
+			header: http.Header{"Grpc-Status": []string{"14"}},
+			want:   false,
+		},
+		{
+			name:   "should not retry INVALID_ARGUMENT",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			header: http.Header{"Grpc-Status": []string{"3"}},
+			want:   false,
+		},
+		{
+			name:   "should not retry OK",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			header: http.Header{"Grpc-Status": []string{"0"}},
+			want:   false,
+		},
+		{
+			name:       "should retry a Connect unavailable error",
+			method:     http.MethodGet,
+			status:     http.StatusServiceUnavailable,
+			header:     http.Header{"Content-Type": []string{"application/json"}},
+			body:       `{"code":"unavailable","message":"try again later"}`,
+			want:       true,
+			wantReason: providers.GRPCStatusRetryReason(14),
+		},
+		{
+			name:   "should not retry a Connect permission denied error",
+			method: http.MethodGet,
+			status: http.StatusForbidden,
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{"code":"permission_denied","message":"nope"}`,
+			want:   false,
+		},
+		{
+			name:   "should not retry a Connect resource exhausted error for a POST",
+			method: http.MethodPost,
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{"code":"resource_exhausted"}`,
+			want:   false,
+		},
+		{
+			name:       "should fall back to the default policy without a gRPC status",
+			method:     http.MethodGet,
+			status:     http.StatusBadGateway,
+			want:       true,
+			wantReason: retryhttp.StatusRetryReason(http.StatusBadGateway),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reason retryhttp.RetryReason
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithMaxRetries(1),
+				retryhttp.WithShouldRetryFn(shouldRetry),
+				retryhttp.WithDelayRecorder(func(attempt retryhttp.Attempt, _ time.Duration) {
+					reason = attempt.Reason
+				}),
+				retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+					header := tt.header
+					if header == nil {
+						header = http.Header{}
+					}
+					return &http.Response{
+						StatusCode: tt.status,
+						Header:     header,
+						Body:       io.NopCloser(strings.NewReader(tt.body)),
+					}, nil
+				})),
+			)
+
+			req, err := http.NewRequest(tt.method, "https://example.com/acme.v1.Service/Method", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			for k, v := range tt.reqHeader {
+				req.Header[k] = v
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected nil error but got %s", err)
+			}
+			defer res.Body.Close()
+
+			if actual := reason != ""; actual != tt.want {
+				t.Errorf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("unexpected reason: got %q, want %q", reason, tt.wantReason)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading body: %s", err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body was not restored: got %s, want %s", string(body), tt.body)
+			}
+		})
+	}
+}
+
+func TestGRPCWebShouldRetryFnTrailer(t *testing.T) {
+	tests := []struct {
+		name         string
+		declared     bool // whether the trailer is announced in the Trailer header
+		status       string
+		wantAttempts int
+	}{
+		{
+			name:         "should retry RESOURCE_EXHAUSTED in a declared trailer",
+			declared:     true,
+			status:       "8",
+			wantAttempts: 2,
+		},
+		{
+			name:         "should retry UNAVAILABLE in an undeclared trailer",
+			status:       "14",
+			wantAttempts: 2,
+		},
+		{
+			name:         "should not retry INVALID_ARGUMENT in the trailer",
+			declared:     true,
+			status:       "3",
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attemptCount := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attemptCount++
+				w.Header().Set("Content-Type", "application/grpc-web+proto")
+				if tt.declared {
+					w.Header().Set("Trailer", "Grpc-Status")
+				}
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, "message")
+				// a streamed response is chunked, which undeclared trailers need
+				w.(http.Flusher).Flush()
+				status := "0"
+				if attemptCount == 1 {
+					status = tt.status
+				}
+				if tt.declared {
+					w.Header().Set("Grpc-Status", status)
+				} else {
+					w.Header().Set(http.TrailerPrefix+"Grpc-Status", status)
+				}
+			}))
+			defer server.Close()
+
+			tr := retryhttp.New(
+				retryhttp.WithTestMode(),
+				retryhttp.WithMaxRetries(1),
+				retryhttp.WithShouldRetryFn(providers.GRPCWebShouldRetryFn()),
+			)
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			res, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("expected nil error but got %s", err)
+			}
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading body: %s", err)
+			}
+			if string(body) != "message" {
+				t.Errorf("body was not restored: got %s, want %s", string(body), "message")
+			}
+			if attemptCount != tt.wantAttempts {
+				t.Errorf("unexpected attempt count: got %d, want %d", attemptCount, tt.wantAttempts)
+			}
+		})
+	}
+}
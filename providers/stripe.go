@@ -0,0 +1,46 @@
+package providers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+// RetryReasonStripeShouldRetry signals a retry requested by the Stripe-Should-Retry header.
+const RetryReasonStripeShouldRetry retryhttp.RetryReason = "stripe-should-retry"
+
+var stripeDelayFn = retryhttp.CustomizedDelayFn(retryhttp.CustomizedDelayFnOptions{
+	Base:            time.Millisecond * 500,
+	Cap:             time.Second * 5,
+	JitterMagnitude: 0.333,
+})
+
+// StripeRetryPolicy returns a [retryhttp.ShouldRetryFn] and [retryhttp.DelayFn] pair suited
+// to the Stripe API. Stripe tells clients whether a failed request is safe to retry with the
+// Stripe-Should-Retry header: if it is true the request is retried regardless of method,
+// since Stripe has determined it is safe to, and if it is false the request is not retried,
+// even for a 429. Responses without the header are handled by
+// [retryhttp.DefaultShouldRetryFn]. Stripe expects non-GET requests to carry an
+// Idempotency-Key header, which also makes them eligible for the default retries.
+// The DelayFn is exponential backoff from 500ms capped at 5s, like Stripe's own client
+// libraries, and respects the Retry-After header.
+func StripeRetryPolicy() (retryhttp.ShouldRetryFn, retryhttp.DelayFn) {
+	shouldRetry := func(attempt retryhttp.Attempt) bool {
+		if attempt.Res != nil {
+			if v := attempt.Res.Header.Get("Stripe-Should-Retry"); v != "" {
+				if retry, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+					if retry {
+						attempt.ReportReason(RetryReasonStripeShouldRetry)
+					}
+					return retry
+				}
+			}
+		}
+
+		return retryhttp.DefaultShouldRetryFn(attempt)
+	}
+
+	return shouldRetry, stripeDelayFn
+}
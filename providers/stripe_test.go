@@ -0,0 +1,134 @@
+package providers_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+	"github.com/justinrixx/retryhttp/providers"
+)
+
+func TestStripeRetryPolicyShouldRetry(t *testing.T) {
+	shouldRetry, _ := providers.StripeRetryPolicy()
+
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		shouldRetry string
+		want        bool
+	}{
+		{
+			name:        "should retry a POST when the header is true",
+			method:      http.MethodPost,
+			status:      http.StatusConflict,
+			shouldRetry: "true",
+			want:        true,
+		},
+		{
+			name:        "should not retry a 429 when the header is false",
+			method:      http.MethodGet,
+			status:      http.StatusTooManyRequests,
+			shouldRetry: "false",
+			want:        false,
+		},
+		{
+			name:        "should not retry an idempotent 503 when the header is false",
+			method:      http.MethodGet,
+			status:      http.StatusServiceUnavailable,
+			shouldRetry: "false",
+			want:        false,
+		},
+		{
+			name:   "should retry a 429 without the header",
+			method: http.MethodPost,
+			status: http.StatusTooManyRequests,
+			want:   true,
+		},
+		{
+			name:   "should not retry a POST 503 without the header",
+			method: http.MethodPost,
+			status: http.StatusServiceUnavailable,
+			want:   false,
+		},
+		{
+			name:        "should fall back to the default with a malformed header",
+			method:      http.MethodPost,
+			status:      http.StatusBadRequest,
+			shouldRetry: "maybe",
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: http.NoBody}
+			if tt.shouldRetry != "" {
+				res.Header.Set("Stripe-Should-Retry", tt.shouldRetry)
+			}
+			req, err := http.NewRequest(tt.method, "https://api.stripe.com/v1/charges", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+
+			if actual := shouldRetry(retryhttp.Attempt{Count: 1, Req: req, Res: res}); actual != tt.want {
+				t.Fatalf("actual != expected: got %t, want %t", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripeRetryPolicyDelay(t *testing.T) {
+	_, delay := providers.StripeRetryPolicy()
+
+	res := &http.Response{Header: http.Header{}}
+	for i := 0; i < 100; i++ {
+		first := delay(retryhttp.Attempt{Count: 1, Res: res})
+		if first < 0 || first > time.Millisecond*500 {
+			t.Fatalf("delay out of range: got %s, want between 0 and %s", first, time.Millisecond*500)
+		}
+
+		capped := delay(retryhttp.Attempt{Count: 100, Res: res})
+		if capped > time.Second*5 {
+			t.Fatalf("delay exceeds cap: got %s, want at most %s", capped, time.Second*5)
+		}
+	}
+}
+
+func TestStripeRetryPolicyTransport(t *testing.T) {
+	attemptCount := 0
+	shouldRetry, _ := providers.StripeRetryPolicy()
+	tr := retryhttp.New(
+		retryhttp.WithShouldRetryFn(shouldRetry),
+		retryhttp.WithDelayFn(func(_ retryhttp.Attempt) time.Duration {
+			return 0
+		}),
+		retryhttp.WithTransport(roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			attemptCount++
+			res := &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(`{"error":{"type":"invalid_request_error","code":"rate_limit"}}`)),
+			}
+			if attemptCount == 2 {
+				res.Header.Set("Stripe-Should-Retry", "false")
+			}
+			return res, nil
+		})),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.stripe.com/v1/charges", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected nil error but got %s", err)
+	}
+	res.Body.Close()
+	if attemptCount != 2 {
+		t.Fatalf("attempt count does not match expected; got %d, want %d", attemptCount, 2)
+	}
+}
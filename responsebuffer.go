@@ -0,0 +1,44 @@
+package retryhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// bufferedBody is a response body that was read into memory by the Transport, as configured
+// with [WithBufferResponseOn], so that it can be inspected any number of times before it is
+// returned.
+type bufferedBody struct {
+	*bytes.Reader
+
+	data []byte
+}
+
+func (b *bufferedBody) Close() error {
+	return nil
+}
+
+// bufferResponse reads the body of res into memory and closes the original, which frees its
+// connection for reuse.
+func bufferResponse(res *http.Response) error {
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	res.Body = &bufferedBody{Reader: bytes.NewReader(data), data: data}
+	return nil
+}
+
+// rewindResponse restores a buffered response body to its beginning, however much of it has
+// been read. Any other body is left as is.
+func rewindResponse(res *http.Response) {
+	if res == nil {
+		return
+	}
+	if b, ok := res.Body.(*bufferedBody); ok {
+		b.Reset(b.data)
+	}
+}
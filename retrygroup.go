@@ -0,0 +1,48 @@
+package retryhttp
+
+import "sync"
+
+// RetryGroup coordinates equivalent requests fanned out at once, such as the same read sent
+// to several replicas, so that once one of them succeeds the others stop retrying. Requests
+// join a group through their context, using [SetRetryGroup]. A member succeeds when it is
+// about to return a 2xx response (that passes the validator configured with
+// [WithResponseValidator], if any), or when [RetryGroup.Succeed] is called. From then on,
+// the other members make no further attempts: a member that is about to decide on a retry
+// returns its last response instead, and one that is waiting out the delay before a retry
+// returns a [*RetriesInterruptedError] matching [ErrRetryGroupSucceeded]. Attempts already
+// in flight are not interrupted.
+//
+// A RetryGroup must be created with [NewRetryGroup], and is safe for concurrent use.
+type RetryGroup struct {
+	once sync.Once
+	done chan struct{}
+}
+
+// NewRetryGroup returns a new RetryGroup that none of its members have succeeded in yet.
+func NewRetryGroup() *RetryGroup {
+	return &RetryGroup{done: make(chan struct{})}
+}
+
+// Succeed marks the group as succeeded, so that its members stop retrying. It is called by
+// the Transport when a member gets a successful response, and can also be called directly,
+// for example once a response body has been checked. Calling it more than once is harmless.
+func (g *RetryGroup) Succeed() {
+	g.once.Do(func() {
+		close(g.done)
+	})
+}
+
+// Done returns a channel that is closed once a member of the group has succeeded.
+func (g *RetryGroup) Done() <-chan struct{} {
+	return g.done
+}
+
+// Succeeded reports whether a member of the group has succeeded.
+func (g *RetryGroup) Succeeded() bool {
+	select {
+	case <-g.done:
+		return true
+	default:
+		return false
+	}
+}
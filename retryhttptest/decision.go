@@ -0,0 +1,190 @@
+// Package retryhttptest provides utilities for testing retry policies built with retryhttp.
+package retryhttptest
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+)
+
+// ErrNoMoreDecisions is returned by [DecisionReplayer] when it is asked for more attempts
+// than it has recorded outcomes for.
+var ErrNoMoreDecisions = errors.New("no more recorded decisions to replay")
+
+// Decision is the outcome of a single attempt, and what the Transport decided to do about it.
+type Decision struct {
+	// Attempt is the number of the attempt, starting at 1 for the initial attempt.
+	Attempt int
+
+	// StatusCode is the status of the attempt's response, or 0 if it returned an error.
+	StatusCode int
+
+	// Header is the header of the attempt's response, or nil if it returned an error.
+	Header http.Header
+
+	// Err is the error the attempt returned, if any.
+	Err error
+
+	// Retry is whether the [retryhttp.ShouldRetryFn] asked for a retry. It is false for an
+	// attempt that the ShouldRetryFn wasn't consulted for, such as the last attempt allowed.
+	Retry bool
+
+	// Delay is the delay decided on before the retry, if one was made.
+	Delay time.Duration
+
+	// Throttled is whether a retry the ShouldRetryFn asked for was not made, for example
+	// because of [retryhttp.WithMaxInFlightRetries] or [retryhttp.WithRetryBudget].
+	Throttled bool
+}
+
+// DecisionRecorder records the [Decision] made after each attempt of a [retryhttp.Transport],
+// so that a complex policy can be covered by a golden test: the recorded decisions can be
+// asserted on, and their outcomes replayed with a [DecisionReplayer]. Decisions are
+// recorded in the order the attempts are made, so a recorder should only be used for one
+// request at a time. The zero value is ready to use.
+type DecisionRecorder struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+// Options returns the options that install the recorder on a Transport. rt is the internal
+// roundtripper and shouldRetry is the policy under test; nil means [http.DefaultTransport]
+// and [retryhttp.DefaultShouldRetryFn] respectively. They replace any roundtripper,
+// [retryhttp.ShouldRetryFn], attempt callback or delay recorder configured before them.
+func (r *DecisionRecorder) Options(rt http.RoundTripper, shouldRetry retryhttp.ShouldRetryFn) []func(*retryhttp.Transport) {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if shouldRetry == nil {
+		shouldRetry = retryhttp.DefaultShouldRetryFn
+	}
+
+	return []func(*retryhttp.Transport){
+		retryhttp.WithOnAttempt(func(attempt retryhttp.Attempt) {
+			r.mu.Lock()
+			r.decisions = append(r.decisions, Decision{Attempt: attempt.Count})
+			r.mu.Unlock()
+		}),
+		retryhttp.WithTransport(recordingTransport{rt: rt, recorder: r}),
+		retryhttp.WithShouldRetryFn(func(attempt retryhttp.Attempt) bool {
+			retry := shouldRetry(attempt)
+			r.update(func(d *Decision) {
+				d.Retry = retry
+			})
+			return retry
+		}),
+		retryhttp.WithDelayRecorder(func(_ retryhttp.Attempt, delay time.Duration) {
+			r.update(func(d *Decision) {
+				d.Delay = delay
+			})
+		}),
+	}
+}
+
+// Decisions returns the decisions recorded so far, in the order of the attempts.
+func (r *DecisionRecorder) Decisions() []Decision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	decisions := make([]Decision, len(r.decisions))
+	copy(decisions, r.decisions)
+	for i := range decisions {
+		// a retry was suppressed if the request didn't continue with another attempt
+		retried := i+1 < len(decisions) && decisions[i+1].Attempt == decisions[i].Attempt+1
+		decisions[i].Throttled = decisions[i].Retry && !retried
+	}
+	return decisions
+}
+
+// Reset discards the decisions recorded so far.
+func (r *DecisionRecorder) Reset() {
+	r.mu.Lock()
+	r.decisions = nil
+	r.mu.Unlock()
+}
+
+// update applies fn to the decision for the latest attempt.
+func (r *DecisionRecorder) update(fn func(d *Decision)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.decisions) > 0 {
+		fn(&r.decisions[len(r.decisions)-1])
+	}
+}
+
+// recordingTransport records the outcome of each attempt on the latest decision.
+type recordingTransport struct {
+	rt       http.RoundTripper
+	recorder *DecisionRecorder
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	t.recorder.update(func(d *Decision) {
+		d.Err = err
+		if res != nil {
+			d.StatusCode = res.StatusCode
+			d.Header = res.Header.Clone()
+		}
+	})
+	return res, err
+}
+
+// DecisionReplayer is an [http.RoundTripper] that replays the outcomes of recorded
+// decisions, in order, as the outcomes of attempts: a response with the recorded status and
+// header and an empty body, or the recorded error. Using it as the internal roundtripper of
+// a Transport drives a policy through the same sequence of attempts again, for example to
+// check that a change to the policy doesn't change its decisions.
+type DecisionReplayer struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+// NewDecisionReplayer returns a DecisionReplayer that replays the outcomes of decisions.
+func NewDecisionReplayer(decisions []Decision) *DecisionReplayer {
+	return &DecisionReplayer{decisions: decisions}
+}
+
+// RoundTrip returns the outcome of the next recorded decision, or [ErrNoMoreDecisions] if
+// they have all been replayed.
+func (p *DecisionReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.decisions) == 0 {
+		return nil, ErrNoMoreDecisions
+	}
+	d := p.decisions[0]
+	p.decisions = p.decisions[1:]
+
+	if d.StatusCode == 0 && d.Err != nil {
+		return nil, d.Err
+	}
+	header := d.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: d.StatusCode,
+		Status:     http.StatusText(d.StatusCode),
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// Remaining returns the number of decisions that haven't been replayed yet.
+func (p *DecisionReplayer) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.decisions)
+}
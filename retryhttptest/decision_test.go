@@ -0,0 +1,146 @@
+package retryhttptest_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/justinrixx/retryhttp"
+	"github.com/justinrixx/retryhttp/retryhttptest"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "timeout error" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func countDelayFn(attempt retryhttp.Attempt) time.Duration {
+	return time.Millisecond * time.Duration(attempt.Count)
+}
+
+func TestDecisionRecorder(t *testing.T) {
+	outcomes := []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{"0"}}, Body: http.NoBody}, nil
+		},
+		func() (*http.Response, error) {
+			return nil, timeoutErr{}
+		},
+		func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		},
+	}
+	attemptCount := 0
+	rt := roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		outcome := outcomes[attemptCount]
+		attemptCount++
+		return outcome()
+	})
+
+	var recorder retryhttptest.DecisionRecorder
+	tr := retryhttp.New(append(recorder.Options(rt, nil), retryhttp.WithDelayFn(countDelayFn))...)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected nil error but got %s", err)
+	}
+	res.Body.Close()
+
+	golden := []retryhttptest.Decision{
+		{Attempt: 1, StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{"0"}}, Retry: true, Delay: time.Millisecond},
+		{Attempt: 2, Err: timeoutErr{}, Retry: true, Delay: time.Millisecond * 2},
+		{Attempt: 3, StatusCode: http.StatusOK, Header: http.Header{}},
+	}
+	decisions := recorder.Decisions()
+	if !reflect.DeepEqual(decisions, golden) {
+		t.Fatalf("unexpected decisions:\ngot  %+v\nwant %+v", decisions, golden)
+	}
+
+	t.Run("should make the same decisions when replayed", func(t *testing.T) {
+		replayer := retryhttptest.NewDecisionReplayer(decisions)
+		var replayed retryhttptest.DecisionRecorder
+		tr := retryhttp.New(append(replayed.Options(replayer, nil), retryhttp.WithDelayFn(countDelayFn))...)
+
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("error creating request: %s", err)
+		}
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("expected nil error but got %s", err)
+		}
+		res.Body.Close()
+
+		if got := replayed.Decisions(); !reflect.DeepEqual(got, golden) {
+			t.Fatalf("unexpected decisions:\ngot  %+v\nwant %+v", got, golden)
+		}
+		if replayer.Remaining() != 0 {
+			t.Fatalf("unexpected remaining decisions: got %d, want %d", replayer.Remaining(), 0)
+		}
+	})
+
+	t.Run("should reveal a changed policy when replayed", func(t *testing.T) {
+		replayer := retryhttptest.NewDecisionReplayer(decisions)
+		var replayed retryhttptest.DecisionRecorder
+		statusOnly := retryhttp.StatusShouldRetryFn(func(status int) bool {
+			return status == http.StatusServiceUnavailable
+		})
+		tr := retryhttp.New(append(replayed.Options(replayer, statusOnly), retryhttp.WithDelayFn(countDelayFn))...)
+
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("error creating request: %s", err)
+		}
+		if _, err := tr.RoundTrip(req); err == nil {
+			t.Fatal("expected the timeout not to be retried")
+		}
+
+		want := []retryhttptest.Decision{golden[0], {Attempt: 2, Err: timeoutErr{}}}
+		if got := replayed.Decisions(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected decisions:\ngot  %+v\nwant %+v", got, want)
+		}
+	})
+}
+
+func TestDecisionRecorderThrottled(t *testing.T) {
+	rt := roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: http.NoBody}, nil
+	})
+
+	var recorder retryhttptest.DecisionRecorder
+	tr := retryhttp.New(append(recorder.Options(rt, nil), retryhttp.WithTestMode(), retryhttp.WithRetryBudget(0.5))...)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("error creating request: %s", err)
+		}
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("expected nil error but got %s", err)
+		}
+		res.Body.Close()
+	}
+
+	// the first request's retry is over budget, but the second's isn't
+	want := []retryhttptest.Decision{
+		{Attempt: 1, StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Retry: true, Throttled: true},
+		{Attempt: 1, StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Retry: true},
+		{Attempt: 2, StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Retry: true, Throttled: true},
+	}
+	if got := recorder.Decisions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected decisions:\ngot  %+v\nwant %+v", got, want)
+	}
+}
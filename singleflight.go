@@ -0,0 +1,168 @@
+package retryhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// flightGroup deduplicates concurrent identical requests so that they share a single
+// stream of attempts. It is an internal equivalent of golang.org/x/sync/singleflight,
+// specialized for HTTP responses, whose bodies can only be read once and must therefore be
+// buffered before they can be shared.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a request that is in flight on behalf of one or more callers.
+type flightCall struct {
+	done chan struct{}
+
+	// waiters is the number of callers still waiting for the call, guarded by the group's
+	// mutex. The shared attempts are canceled once every caller has given up.
+	waiters int
+	cancel  context.CancelFunc
+
+	// res, body, err, and panicked are only safe to read once done is closed
+	res      *http.Response
+	body     []byte
+	err      error
+	panicked interface{}
+}
+
+// flightKey identifies the requests that may share a stream of attempts. Requests are only
+// identical if they have the same method, URL, and headers, so that callers with different
+// credentials or content negotiation never receive each other's responses.
+func flightKey(req *http.Request) string {
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+	for _, name := range names {
+		// header names and values can't contain newlines, so they can't be confused
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(req.Header[name], "\n\t"))
+	}
+	return b.String()
+}
+
+// detachedContext carries the values of another context without its cancellation or
+// deadline, so that shared attempts outlive the caller that started them.
+type detachedContext struct {
+	context.Context
+
+	values context.Context
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// do calls fn once for all concurrent callers with the same key, and returns each caller its
+// own copy of the response. fn is called with a context that carries the values of the
+// first caller's context, but is only canceled once every caller has given up. A caller
+// whose context expires while waiting returns early with the context's error; the shared
+// request is unaffected as long as other callers are waiting. If fn panics, the first caller
+// panics with the same value if it is still waiting, and the other callers return an error.
+func (g *flightGroup) do(ctx context.Context, key string, req *http.Request, fn func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+
+		return g.wait(ctx, key, c, req, false)
+	}
+
+	shared, cancel := context.WithCancel(context.Background())
+	c := &flightCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	go g.call(detachedContext{Context: shared, values: ctx}, key, c, fn)
+
+	return g.wait(ctx, key, c, req, true)
+}
+
+// call makes the shared call and publishes its result, even if fn panics.
+func (g *flightGroup) call(ctx context.Context, key string, c *flightCall, fn func(ctx context.Context) (*http.Response, error)) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.panicked = p
+			c.res, c.err = nil, fmt.Errorf("panic in shared request: %v", p)
+		}
+
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+
+	c.res, c.err = fn(ctx)
+	if c.res != nil {
+		if c.err == nil {
+			c.body, c.err = io.ReadAll(c.res.Body)
+		}
+		c.res.Body.Close()
+	}
+}
+
+// wait blocks until c is done or ctx expires. The caller that started c re-panics if fn
+// panicked.
+func (g *flightGroup) wait(ctx context.Context, key string, c *flightCall, req *http.Request, first bool) (*http.Response, error) {
+	select {
+	case <-c.done:
+		if first && c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.response(req)
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody is left to receive the response, so new callers start over
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			c.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// response returns a copy of the shared response with its own body and headers, so each
+// caller can consume and modify it independently.
+func (c *flightCall) response(req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	res := new(http.Response)
+	*res = *c.res
+	res.Header = c.res.Header.Clone()
+	res.Trailer = c.res.Trailer.Clone()
+	res.Body = io.NopCloser(bytes.NewReader(c.body))
+	res.Request = req
+
+	return res, nil
+}
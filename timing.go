@@ -0,0 +1,21 @@
+package retryhttp
+
+import "time"
+
+// TimingReport breaks down the time [Transport] spent on a request into the time spent
+// making attempts and the time spent waiting between them. It is reported by the callback
+// configured with [WithTimingRecorder]. Whatever remains of Total once the attempts and
+// delays are subtracted was spent by [Transport] itself, such as buffering the request body.
+type TimingReport struct {
+	// Total is the time from the start of the request until its outcome was returned.
+	Total time.Duration
+
+	// Attempts holds the duration of each attempt, in order, from sending the request until
+	// its response headers were received or it failed. Reading the response body isn't
+	// included, since that happens after the attempt for the response that is returned.
+	Attempts []time.Duration
+
+	// Delays holds the time spent waiting before each retry, in order. The last delay is
+	// shorter than the one computed if the request's context expired during it.
+	Delays []time.Duration
+}
@@ -3,10 +3,14 @@ package retryhttp
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,6 +19,10 @@ import (
 // [WithMaxRetries].
 const DefaultMaxRetries = 3
 
+// DefaultExposeAttemptHeader is the response header [WithExposeAttemptHeader] sets if it isn't
+// given a name.
+const DefaultExposeAttemptHeader = "X-Retryhttp-Attempts"
+
 var (
 	// ErrBufferingBody is a sentinel that signals an error before the response was sent. Since
 	// request body streams can only be consumed once, they must be buffered into memory before
@@ -28,6 +36,57 @@ var (
 	// returned in a new error wrapping this sentinel. A caller can identify this case using
 	// errors.Is(err, ErrSeekingBody).
 	ErrSeekingBody = errors.New("error seeking body buffer back to beginning after attempt")
+
+	// ErrRetriesInterrupted is a sentinel that signals the parent context expired while
+	// [Transport] was waiting to make another attempt. The error returned in this case is a
+	// [*RetriesInterruptedError], which matches both this sentinel and the context's error
+	// using errors.Is.
+	ErrRetriesInterrupted = errors.New("retries interrupted before next attempt")
+
+	// ErrRoundTripperPanicked is a sentinel that signals the internal roundtripper panicked
+	// during an attempt. It is only returned if [WithRecoverPanics] is enabled; otherwise the
+	// panic is propagated after cleaning up. A caller can identify this case using
+	// errors.Is(err, ErrRoundTripperPanicked).
+	ErrRoundTripperPanicked = errors.New("internal roundtripper panicked")
+
+	// ErrUnbufferableBody is returned when [WithRefuseBodyBuffering] is enabled and a request
+	// has a body that could only be replayed by buffering it into memory. The request is not
+	// sent. To resolve it, either provide GetBody on the request or prevent retries for it
+	// using [SetPreventRetryWithBody].
+	ErrUnbufferableBody = errors.New("request body has no GetBody and buffering it into memory for retries is refused; set GetBody or prevent retries with body")
+
+	// ErrInvalidConfig is a sentinel that signals [NewWithError] was provided options that
+	// don't make sense, such as a negative number of retries. The error returned in this case
+	// wraps this sentinel and describes the problem. A caller can identify this case using
+	// errors.Is(err, ErrInvalidConfig).
+	ErrInvalidConfig = errors.New("invalid transport configuration")
+
+	// ErrReplayingBody is a sentinel that signals reading the request body failed while it
+	// was being replayed for a retry, for a body that is replayed by seeking or using GetBody
+	// rather than from an in-memory buffer, or that GetBody itself failed. No further
+	// attempts are made, since they would send a partial body. The error returned in this
+	// case wraps this sentinel. A caller can identify this case using
+	// errors.Is(err, ErrReplayingBody).
+	ErrReplayingBody = errors.New("error reading body while replaying it for a retry")
+
+	// ErrRetriesExhausted is a sentinel that signals the last attempt allowed by the maximum
+	// number of retries would have been retried otherwise. It is only returned if
+	// [WithRetriesExhaustedError] is enabled. The error returned in this case is a
+	// [*RetriesExhaustedError], which matches this sentinel using errors.Is.
+	ErrRetriesExhausted = errors.New("retries exhausted")
+
+	// ErrHostConcurrencyLimit is the error of an attempt that wasn't made because the limit
+	// on attempts in flight to its host, configured with [WithMaxAttemptsPerHost], was
+	// reached and the Transport is configured to fail fast rather than wait. Like any other
+	// attempt error, it is passed to the [ShouldRetryFn]; [DefaultShouldRetryFn] doesn't
+	// retry it. A caller can identify this case using errors.Is(err, ErrHostConcurrencyLimit).
+	ErrHostConcurrencyLimit = errors.New("too many attempts in flight to host")
+
+	// ErrRetryGroupSucceeded is a sentinel that signals a request stopped retrying because
+	// another member of its [RetryGroup] succeeded while it was waiting to make another
+	// attempt. The error returned in this case is a [*RetriesInterruptedError], which matches
+	// both this sentinel and [ErrRetriesInterrupted] using errors.Is.
+	ErrRetryGroupSucceeded = errors.New("another request in the retry group succeeded")
 )
 
 type (
@@ -48,43 +107,295 @@ type (
 
 		// Err is an optional error that may have occurred during the HTTP round trip.
 		Err error
+
+		// Reason is a machine-readable description of why a retry is being made. It is
+		// populated after the [ShouldRetryFn] has been consulted, so it is available to the
+		// [DelayFn] but not to the [ShouldRetryFn] itself. It is empty if the
+		// [ShouldRetryFn] did not report a reason using [Attempt.ReportReason].
+		Reason RetryReason
+
+		// Overloaded is whether the attempt's outcome is a sign that the destination is
+		// overloaded, as decided by the predicate configured with [WithOverloadSignal]. Only
+		// retries of overloaded attempts are subject to [WithMaxInFlightRetries] and
+		// [WithRetryBudget]. If no predicate is configured, every attempt made by [Transport]
+		// is considered overloaded.
+		Overloaded bool
+
+		// LastDelay is the delay that was waited before this attempt, as computed by the
+		// [DelayFn] or [Backoff] for the previous attempt. It is 0 for the initial attempt.
+		// It allows strategies that grow from the previous delay, such as decorrelated
+		// jitter, to be written as a stateless [DelayFn].
+		LastDelay time.Duration
+
+		// Values holds state shared by the callbacks consulted for a request, such as a
+		// result that a [ShouldRetryFn] computed once and a [DelayFn] reuses. The same map is
+		// passed to every callback for every attempt of a request, and a new one is created
+		// for each request. Keys should be of an unexported type to avoid collisions, as with
+		// context values. It is nil for Attempts that were not created by [Transport].
+		Values map[interface{}]interface{}
+
+		reason           *RetryReason
+		host             string    // the host the attempt was sent to, which failover may change
+		started          time.Time // when the attempt was sent
+		assumeIdempotent bool
+		retryAfterParser func(value string) (time.Duration, bool)
+		redactedHeaders  []string
 	}
 
+	// ConnectionReusePolicy determines what [Transport] does with the response body of an
+	// attempt that is going to be retried. See [WithConnectionReusePolicy].
+	ConnectionReusePolicy int
+
+	// RetryReason is a machine-readable description of why a retry was made, such as
+	// "dns-error" or "status-503".
+	RetryReason string
+
+	// RetryCategory is a coarse classification of what caused an attempt to fail, suitable
+	// as a metrics label. See [Attempt.Category].
+	RetryCategory string
+
 	// ShouldRetryFn is a callback type consulted by [Transport] to determine if another attempt
 	// should be made after the current one.
 	ShouldRetryFn func(attempt Attempt) bool
 
+	// ShouldRetryResult is the decision of a [ShouldRetryResultFn].
+	ShouldRetryResult struct {
+		// Retry is whether another attempt should be made.
+		Retry bool
+
+		// SkipDelay is whether that attempt should be made immediately, without consulting
+		// the [DelayFn] or [Backoff]. It is ignored if Retry is false.
+		SkipDelay bool
+	}
+
+	// ShouldRetryResultFn is a richer alternative to [ShouldRetryFn] that can also ask for a
+	// retry to be made immediately. See [WithShouldRetryResultFn].
+	ShouldRetryResultFn func(attempt Attempt) ShouldRetryResult
+
 	// DelayFn is a callback type consulted by [Transport] to determine how long to wait before
 	// the next attempt.
 	DelayFn func(attempt Attempt) time.Duration
 
+	// Backoff is a stateful alternative to [DelayFn]. It is useful for strategies that need
+	// to remember their own previous outputs, such as decorrelated jitter. A fresh Backoff is
+	// created for every request using the factory configured with [WithBackoff].
+	Backoff interface {
+		// Next returns how long to wait before the next attempt.
+		Next(attempt Attempt) time.Duration
+
+		// Reset restores the Backoff to its initial state. It is called once before the
+		// Backoff is first used for a request.
+		Reset()
+	}
+
+	// RetryGate reports whether retries are currently allowed. It is consulted before every
+	// retry, so it can be flipped at any time, typically by an external health monitor. See
+	// [WithRetryGate].
+	RetryGate func() bool
+
 	// Transport implements [http.RoundTripper] and can be configured with many options. See
 	// the documentation for the [New] function.
 	Transport struct {
 		rt                   http.RoundTripper
 		maxRetries           *int // pointer to differentiate between 0 and unset
+		hardMaxRetries       *int
+		maxRetriesPerStatus  map[int]int
 		shouldRetryFn        ShouldRetryFn
+		shouldRetryResultFn  ShouldRetryResultFn // nil unless configured with WithShouldRetryResultFn
 		delayFn              DelayFn
+		newBackoff           func() Backoff
 		preventRetryWithBody bool
+		allowRetryGetBody    bool
 		attemptTimeout       time.Duration
+		attemptTimeoutFn     func(req *http.Request) time.Duration
+		skipDoomed           bool
+		minRoundTrip         time.Duration
+		retrySem             chan struct{} // nil if in-flight retries are unlimited
+		hostLimiter          *hostLimiter  // nil if attempts per host are unlimited
+		hostSpacer           *hostSpacer   // nil if attempts to a host aren't spaced out
+		hostBackoff          *hostBackoff  // nil unless configured with WithHostBackoff
+		retryOnTrailerFn     func(trailer http.Header) bool
+		retryAfterCap        time.Duration
+		maxRetryAfter        time.Duration
+		cancellationGrace    time.Duration
+		maxTotalDownload     int64
+		onLongRetryAfter     func(attempt Attempt, retryAfter time.Duration)
+		retryAfterParser     func(value string) (time.Duration, bool)
+		redactedHeaders      []string
+		sizeRecorder         func(attempt Attempt, sent, received int64)
+		attemptHeader        string
+		exposeAttemptHeader  string
+		responseRetryHeader  string
+		responseTransformer  func(res *http.Response) (*http.Response, error)
+		bufferResponseOn     func(status int) bool
+		connReusePolicy      ConnectionReusePolicy
+		disabled             bool
+		retryPathMatcher     func(u *url.URL) bool
+		retryBudget          *retryBudget  // nil if retries are not budgeted
+		bufferBudget         *bufferBudget // nil if buffered bytes are unlimited
+		onThrottle           func(attempt Attempt)
+		assumeIdempotent     bool
+		refuseBuffering      bool
+		delayRecorder        func(attempt Attempt, delay time.Duration)
+		timingRecorder       func(report TimingReport)
+		retryAfterRecorder   func(attempt Attempt, err *RetryAfterParseError)
+		onAttempt            func(attempt Attempt)
+		beforeAttempt        func(attempt Attempt) error
+		deadlineHeader       string
+		connTraceRecorder    func(attempt Attempt, trace ConnTrace)
+		overloadSignal       func(attempt Attempt) bool
+		retryGate            RetryGate
+		retryPrecondition    func(ctx context.Context) bool
+		failoverHosts        []failoverHost
+		attemptCountContext  bool
+		addressRotation      bool
+		retriesExhaustedErr  bool
+		autoIdempotencyKey   string
+		idempotencyKeyRand   io.Reader // crypto/rand if nil
+		recoverPanics        bool
+		responseValidator    func(res *http.Response) error
+		flights              *flightGroup // nil if single-flight is disabled
 		initOnce             sync.Once
 	}
 )
 
+const (
+	// RetryReasonDNSError signals a retry due to a DNS error.
+	RetryReasonDNSError RetryReason = "dns-error"
+
+	// RetryReasonDialError signals a retry due to failing to establish a connection. See
+	// [IsDialErr].
+	RetryReasonDialError RetryReason = "dial-error"
+
+	// RetryReasonTimeout signals a retry due to a timeout error.
+	RetryReasonTimeout RetryReason = "timeout"
+
+	// RetryReasonRetryAfter signals a retry due to the presence of the Retry-After header.
+	RetryReasonRetryAfter RetryReason = "retry-after-header"
+
+	// RetryReasonTrailer signals a retry due to the predicate configured with
+	// [WithRetryOnTrailer].
+	RetryReasonTrailer RetryReason = "trailer"
+
+	// RetryReasonTLSHandshake signals a retry due to a transient TLS handshake error. See
+	// [IsTLSHandshakeErr].
+	RetryReasonTLSHandshake RetryReason = "tls-handshake"
+
+	// RetryReasonIdleConnClosed signals a retry due to the server closing a reused keep-alive
+	// connection. See [IsIdleConnClosedErr].
+	RetryReasonIdleConnClosed RetryReason = "idle-conn-closed"
+
+	// RetryReasonErrorSubstring signals a retry due to an error message matched by
+	// [RetryOnErrorSubstrings].
+	RetryReasonErrorSubstring RetryReason = "error-substring"
+
+	// RetryReasonInvalidResponse signals a retry due to a successful response rejected by
+	// the validator configured with [WithResponseValidator].
+	RetryReasonInvalidResponse RetryReason = "invalid-response"
+)
+
+const (
+	// RetryCategoryNetwork signals an attempt that failed without a response, such as with
+	// a DNS error, a timeout, or a reset connection. This points at the network or the
+	// client rather than the destination.
+	RetryCategoryNetwork RetryCategory = "network"
+
+	// RetryCategoryServer signals an attempt that got a response the destination meant as a
+	// failure, such as a 429 or a 503. This points at the destination, for example because
+	// it is overloaded.
+	RetryCategoryServer RetryCategory = "server"
+)
+
+const (
+	// ConnectionReuseDrain reads the rest of a response body before closing it, which allows
+	// the underlying connection to be reused for the next attempt. This is the default.
+	ConnectionReuseDrain ConnectionReusePolicy = iota
+
+	// ConnectionReuseClose closes a response body without reading the rest of it. This
+	// saves the cost of draining large bodies, but the underlying connection can't be reused
+	// and the next attempt may need to establish a new one.
+	ConnectionReuseClose
+)
+
+// failoverHost is an alternate host configured with [WithFailoverHosts].
+type failoverHost struct {
+	scheme string // empty to keep the request's scheme
+	host   string
+}
+
+// delayFnBackoff adapts a stateless [DelayFn] to the [Backoff] interface.
+type delayFnBackoff DelayFn
+
+func (fn delayFnBackoff) Next(attempt Attempt) time.Duration {
+	return fn(attempt)
+}
+
+func (fn delayFnBackoff) Reset() {}
+
+// StatusRetryReason returns the [RetryReason] for a retry due to the response status code,
+// for example "status-503".
+func StatusRetryReason(status int) RetryReason {
+	return RetryReason("status-" + strconv.Itoa(status))
+}
+
+// ReportReason is used by a [ShouldRetryFn] to record why it decided a retry is
+// appropriate. The reason is made available as [Attempt.Reason] to callbacks consulted
+// afterwards. Calling it on an Attempt that was not created by [Transport] has no effect.
+func (a Attempt) ReportReason(reason RetryReason) {
+	if a.reason != nil {
+		*a.reason = reason
+	}
+}
+
+// Category classifies the attempt's failure by whether it was caused by the network or by
+// the destination: [RetryCategoryNetwork] if the attempt ended in an error without a
+// response, and [RetryCategoryServer] if it got a response. This lets the callbacks that
+// emit metrics, such as the ones configured with [WithDelayRecorder] or [WithOnThrottle],
+// tell a flaky network from an overloaded backend. It is empty for an attempt that hasn't
+// been made yet.
+func (a Attempt) Category() RetryCategory {
+	if a.Res != nil {
+		return RetryCategoryServer
+	}
+	if a.Err != nil {
+		return RetryCategoryNetwork
+	}
+	return ""
+}
+
 // New is used to construct a new [Transport], configured with any desired options.
 // These options include [WithTransport], [WithMaxRetries], [WithShouldRetryFn],
 // [WithDelayFn], and [WithPreventRetryWithBody]. Any number of options may be provided.
 // If the same option is provided multiple times, the latest one takes precedence.
+// Negative counts and durations are clamped to zero; use [NewWithError] to reject them
+// instead.
 func New(options ...func(*Transport)) *Transport {
 	tr := &Transport{}
 
 	for _, option := range options {
 		option(tr)
 	}
+	tr.clamp()
 
 	return tr
 }
 
+// NewWithError is like [New], but validates the resulting configuration rather than
+// silently correcting it. It returns an error wrapping [ErrInvalidConfig] describing the
+// first problem found, such as a negative number of retries or attempt timeout.
+func NewWithError(options ...func(*Transport)) (*Transport, error) {
+	tr := &Transport{}
+
+	for _, option := range options {
+		option(tr)
+	}
+	if err := tr.validate(); err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
 func (t *Transport) init() {
 	if t.rt == nil {
 		t.rt = http.DefaultTransport
@@ -92,7 +403,7 @@ func (t *Transport) init() {
 	if t.shouldRetryFn == nil {
 		t.shouldRetryFn = DefaultShouldRetryFn
 	}
-	if t.delayFn == nil {
+	if t.delayFn == nil && t.newBackoff == nil {
 		t.delayFn = DefaultDelayFn
 	}
 
@@ -110,26 +421,114 @@ func (t *Transport) init() {
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	t.initOnce.Do(t.init)
 
+	ctx := req.Context()
+
+	disabled := t.disabled
+	ctxDisabled, set := getDisabledFromContext(ctx)
+	if set {
+		disabled = ctxDisabled
+	}
+	if disabled || (t.retryPathMatcher != nil && !t.retryPathMatcher(req.URL)) {
+		return t.rt.RoundTrip(req)
+	}
+
+	if t.flights != nil && (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody) {
+		return t.flights.do(ctx, flightKey(req), req, func(ctx context.Context) (*http.Response, error) {
+			return t.roundTrip(req.WithContext(ctx))
+		})
+	}
+
+	return t.roundTrip(req)
+}
+
+// roundTrip makes the attempts for a single request, retrying as configured.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
 	var attemptCount int
 	ctx := req.Context()
 
+	var timing *TimingReport
+	if t.timingRecorder != nil {
+		timing = &TimingReport{}
+		start := time.Now()
+		defer func() {
+			timing.Total = time.Since(start)
+			t.timingRecorder(*timing)
+		}()
+	}
+
+	// the caller's request must not be modified, but its body is replaced so that it can be
+	// replayed, so the attempts are made using a copy. A request without a body doesn't
+	// need one, which saves an allocation in the most common case.
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.WithContext(ctx)
+	}
+
+	if t.autoIdempotencyKey != "" && !defaultIdempotentMethods[req.Method] && req.Header.Get(t.autoIdempotencyKey) == "" {
+		key, err := newIdempotencyKey(t.idempotencyKeyRand)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, fmt.Errorf("error generating idempotency key: %w", err)
+		}
+
+		header := req.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set(t.autoIdempotencyKey, key)
+		if req.Body == nil || req.Body == http.NoBody {
+			req = req.WithContext(ctx)
+		}
+		req.Header = header
+	}
+
+	if t.retryBudget != nil {
+		t.retryBudget.recordRequest()
+	}
+
 	maxRetries := *t.maxRetries
 	ctxRetries, set := getMaxRetriesFromContext(ctx)
 	if set {
 		maxRetries = ctxRetries
 	}
+	if t.hardMaxRetries != nil && maxRetries > *t.hardMaxRetries {
+		maxRetries = *t.hardMaxRetries
+	}
+
+	maxRetriesPerStatus := t.maxRetriesPerStatus
+	ctxMaxRetriesPerStatus, set := getMaxRetriesPerStatusFromContext(ctx)
+	if set {
+		maxRetriesPerStatus = ctxMaxRetriesPerStatus
+	}
+	var statusRetries map[int]int // retries made so far following each status
+	if maxRetriesPerStatus != nil {
+		statusRetries = map[int]int{}
+	}
+	// a request that isn't in a group waits on a nil channel, which never receives
+	group := getRetryGroupFromContext(ctx)
+	var groupDone <-chan struct{}
+	if group != nil {
+		groupDone = group.Done()
+	}
+	serverMaxRetries := -1 // the lowest limit set by the response retry header, if any
 
 	shouldRetryFn := t.shouldRetryFn
+	shouldRetryResultFn := t.shouldRetryResultFn
 	ctxShouldRetryFn, set := getShouldRetryFnFromContext(ctx)
 	if set {
 		shouldRetryFn = ctxShouldRetryFn
+		shouldRetryResultFn = nil
 	}
 
 	delayFn := t.delayFn
+	newBackoff := t.newBackoff
 	ctxDelayFn, set := getDelayFnFromContext(ctx)
 	if set {
 		delayFn = ctxDelayFn
+		newBackoff = nil
 	}
+	var backoff Backoff
 
 	preventRetryWithBody := t.preventRetryWithBody
 	ctxPreventRetry, set := getPreventRetryWithBodyFromContext(ctx)
@@ -137,80 +536,797 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		preventRetryWithBody = ctxPreventRetry
 	}
 
-	preventRetry := req.Body != nil && req.Body != http.NoBody && preventRetryWithBody
+	allowRetryGetBody := t.allowRetryGetBody
+	ctxAllowRetryGetBody, set := getAllowRetryWithGetBodyFromContext(ctx)
+	if set {
+		allowRetryGetBody = ctxAllowRetryGetBody
+	}
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
 
-	// if body is present, it must be buffered if there is any chance of a retry
+	// a seekable body can be rewound for each attempt instead of being buffered
+	var seeker io.ReadSeeker
+	var seekStart, seekSize int64
+	if hasBody {
+		seeker, seekStart, seekSize = seekableBody(req.Body)
+		if seeker != nil && seekSize == 0 {
+			req.Body.Close()
+			req.Body = http.NoBody
+			hasBody = false
+		}
+	}
+
+	refuseBuffering := t.refuseBuffering
+	ctxRefuseBuffering, set := getRefuseBodyBufferingFromContext(ctx)
+	if set {
+		refuseBuffering = ctxRefuseBuffering
+	}
+
+	// a body that can be recreated using GetBody does not need to be buffered, so the
+	// reason for preventing retries does not apply if the caller has opted in
+	replayWithGetBody := hasBody && req.GetBody != nil &&
+		((preventRetryWithBody && allowRetryGetBody) || (!preventRetryWithBody && refuseBuffering))
+	preventRetry := hasBody && preventRetryWithBody && !replayWithGetBody
+
+	// a body of unknown length that would otherwise be buffered may turn out to be empty, in
+	// which case there is nothing to buffer or replay and it is treated the same as no body at
+	// all. Other bodies aren't peeked, since a streaming body may not produce anything until
+	// the request is under way.
+	if hasBody && !preventRetry && !replayWithGetBody && seeker == nil && req.ContentLength == 0 {
+		body, empty, err := peekEmptyBody(req.Body)
+		if err != nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("%w: %s", ErrBufferingBody, err)
+		}
+		req.Body = body
+		hasBody = !empty
+	}
+
+	// a body retries are prevented for isn't peeked either, but once an attempt has read it
+	// to the end without finding anything, there is nothing to stop retries without it
+	var emptyCheck *emptyCheckReader
+	if preventRetry && seeker == nil && req.ContentLength == 0 {
+		emptyCheck = &emptyCheckReader{ReadCloser: req.Body}
+		req.Body = emptyCheck
+	}
+
+	if hasBody && !preventRetry && !replayWithGetBody && seeker == nil && refuseBuffering {
+		req.Body.Close()
+		return nil, ErrUnbufferableBody
+	}
+
+	// if body is present, it must be rewound or buffered if there is any chance of a retry
 	// since it can only be consumed once.
 	var br *bytes.Reader
-	if req.Body != nil && req.Body != http.NoBody && !preventRetry {
-		var buf bytes.Buffer
-		if _, err := io.Copy(&buf, req.Body); err != nil {
+	replayWithSeek := hasBody && !preventRetry && !replayWithGetBody && seeker != nil
+	if replayWithSeek {
+		// the internal roundtripper closes the body after each attempt, so it is only
+		// closed once all attempts are done
+		defer req.Body.Close()
+		req.Body = io.NopCloser(seeker)
+	} else if hasBody && !preventRetry && !replayWithGetBody {
+		buf, fits, err := t.bufferBody(req.Body, req.ContentLength)
+		if err != nil {
 			req.Body.Close()
 			return nil, fmt.Errorf("%w: %s", ErrBufferingBody, err)
 		}
-		req.Body.Close()
 
-		br = bytes.NewReader(buf.Bytes())
-		req.Body = io.NopCloser(br)
+		if fits {
+			req.Body.Close()
+			if t.bufferBudget != nil {
+				defer t.bufferBudget.release(int64(len(buf)))
+			}
+
+			br = bytes.NewReader(buf)
+			req.Body = io.NopCloser(br)
+		} else {
+			// other requests have used up the buffer budget, so this one is sent once, as
+			// it is streamed, after whatever was read of it already
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+			preventRetry = true
+		}
+	}
+
+	// the number of bytes sent in each attempt, for the size recorder
+	var sentSize int64
+	if br != nil {
+		sentSize = br.Size()
+	} else if replayWithSeek {
+		sentSize = seekSize
+	} else if hasBody && req.ContentLength > 0 {
+		sentSize = req.ContentLength
 	}
 
 	attemptTimeout := t.attemptTimeout
+	if t.attemptTimeoutFn != nil {
+		attemptTimeout = t.attemptTimeoutFn(req)
+	}
 	ctxAttemptTimeout, set := getAttemptTimeoutFromContext(ctx)
 	if set {
 		attemptTimeout = ctxAttemptTimeout
 	}
 
+	retryOnTrailerFn := t.retryOnTrailerFn
+	ctxRetryOnTrailerFn, set := getRetryOnTrailerFnFromContext(ctx)
+	if set {
+		retryOnTrailerFn = ctxRetryOnTrailerFn
+	}
+
+	retryAfterCap := t.retryAfterCap
+	ctxRetryAfterCap, set := getRetryAfterCapFromContext(ctx)
+	if set {
+		retryAfterCap = ctxRetryAfterCap
+	}
+
+	connReusePolicy := t.connReusePolicy
+	ctxConnReusePolicy, set := getConnectionReusePolicyFromContext(ctx)
+	if set {
+		connReusePolicy = ctxConnReusePolicy
+	}
+
+	assumeIdempotent := t.assumeIdempotent
+	ctxAssumeIdempotent, set := getAssumeIdempotentFromContext(ctx)
+	if set {
+		assumeIdempotent = ctxAssumeIdempotent
+	}
+
+	// a slot in retrySem is held from the decision to retry until that retry completes
+	var holdingRetry bool
+	defer func() {
+		if holdingRetry {
+			<-t.retrySem
+		}
+	}()
+
+	// a deadline hint from a header, for callers that can't plumb a context deadline through
+	var headerDeadline time.Time
+	if t.deadlineHeader != "" {
+		headerDeadline, _ = parseDeadlineHeader(req.Header.Get(t.deadlineHeader), time.Now())
+	}
+
+	// shared by the callbacks for every attempt of this request
+	values := map[interface{}]interface{}{}
+
+	// the body being replayed for the current retry, if it isn't replayed from memory
+	var replay *replayReader
+
+	// the number of retries that failed over to another host
+	var failovers int
+
+	// the number of retries that moved on to another resolved address
+	var rotations int
+
+	// the delay waited before the current attempt
+	var lastDelay time.Duration
+
+	// bytes read from the response bodies of earlier attempts, and the body of the current
+	// attempt, if downloads are limited
+	var downloaded int64
+	var download *countingReader
+
+	// reported by the ShouldRetryFn for the current attempt. It is declared once, since a
+	// pointer to it escapes with each attempt.
+	var reason RetryReason
+
 	for {
-		// set per-attempt timeout if needed
-		var cancel context.CancelFunc = func() {}
+		// an attempt started before the request's context is canceled may outlive it by the
+		// grace period
+		var cancel context.CancelFunc
+		attemptCtx := ctx
+		if t.cancellationGrace > 0 && ctx.Err() == nil {
+			attemptCtx, cancel = withCancellationGrace(ctx, t.cancellationGrace)
+		}
+
+		// set per-attempt timeout if needed. Without one or a grace period there is nothing
+		// to cancel, and cancel is left nil.
 		reqWithTimeout := req
 		if attemptTimeout != 0 {
-			var timeoutCtx context.Context
-			timeoutCtx, cancel = context.WithTimeout(ctx, attemptTimeout)
+			timeoutCtx, cancelTimeout := context.WithTimeout(attemptCtx, attemptTimeout)
+			if cancelGrace := cancel; cancelGrace != nil {
+				cancel = func() {
+					cancelTimeout()
+					cancelGrace()
+				}
+			} else {
+				cancel = cancelTimeout
+			}
 			reqWithTimeout = req.WithContext(timeoutCtx)
+		} else if attemptCtx != ctx {
+			reqWithTimeout = req.WithContext(attemptCtx)
+		}
+
+		// the caller's request must not be modified, so the header is set on a copy
+		if t.attemptHeader != "" {
+			if reqWithTimeout == req {
+				reqWithTimeout = req.WithContext(req.Context())
+			}
+			reqWithTimeout.Header = req.Header.Clone()
+			reqWithTimeout.Header.Set(t.attemptHeader, strconv.Itoa(attemptCount+1))
+		}
+
+		// retries of idempotent requests rotate through the failover hosts, after the
+		// request's own host
+		if n := failovers % (len(t.failoverHosts) + 1); n > 0 {
+			host := t.failoverHosts[n-1]
+			if reqWithTimeout == req {
+				reqWithTimeout = req.WithContext(req.Context())
+			}
+			u := *req.URL
+			u.Host = host.host
+			if host.scheme != "" {
+				u.Scheme = host.scheme
+			}
+			reqWithTimeout.URL = &u
+			reqWithTimeout.Host = ""
+		}
+
+		if rotations > 0 {
+			reqWithTimeout = reqWithTimeout.WithContext(context.WithValue(reqWithTimeout.Context(), addressRotationContextKey, rotations))
+		}
+
+		if t.attemptCountContext {
+			reqWithTimeout = reqWithTimeout.WithContext(context.WithValue(reqWithTimeout.Context(), attemptCountContextKey, attemptCount+1))
+		}
+
+		// connection events are only traced if someone is listening
+		var tracer *connTracer
+		if t.connTraceRecorder != nil {
+			tracer = &connTracer{}
+			reqWithTimeout = reqWithTimeout.WithContext(tracer.withTrace(reqWithTimeout.Context()))
+		}
+
+		// the callback may modify the request, so it is given a copy with its own header
+		if t.beforeAttempt != nil {
+			if reqWithTimeout == req {
+				reqWithTimeout = req.WithContext(req.Context())
+			}
+			reqWithTimeout.Header = reqWithTimeout.Header.Clone()
+			if reqWithTimeout.Header == nil {
+				reqWithTimeout.Header = http.Header{}
+			}
+
+			if berr := t.beforeAttempt(Attempt{Count: attemptCount + 1, Req: reqWithTimeout, Values: values, assumeIdempotent: assumeIdempotent, redactedHeaders: t.redactedHeaders}); berr != nil {
+				if reqWithTimeout.Body != nil {
+					reqWithTimeout.Body.Close()
+				}
+				discardAttempt(nil, cancel)
+				return nil, fmt.Errorf("error preparing attempt %d: %w", attemptCount+1, berr)
+			}
+		}
+
+		if t.onAttempt != nil {
+			t.onAttempt(Attempt{Count: attemptCount + 1, Req: reqWithTimeout, Values: values, assumeIdempotent: assumeIdempotent, redactedHeaders: t.redactedHeaders})
 		}
 
-		// the actual round trip
-		res, err := t.rt.RoundTrip(reqWithTimeout)
+		// the actual round trip, once the host is ready for it
+		attemptStart := time.Now()
+		var res *http.Response
+		var err error
+		release := func() {}
+		if t.hostLimiter != nil {
+			release, err = t.hostLimiter.acquire(reqWithTimeout.Context(), reqWithTimeout.URL.Host)
+		}
+		if err == nil && t.hostSpacer != nil {
+			if err = t.hostSpacer.wait(reqWithTimeout.Context(), reqWithTimeout.URL.Host); err != nil {
+				release()
+			}
+		}
+		if err == nil {
+			res, err = t.attempt(reqWithTimeout, cancel)
+			release()
+		} else if reqWithTimeout.Body != nil {
+			reqWithTimeout.Body.Close()
+		}
 		attemptCount++
+		if timing != nil {
+			timing.Attempts = append(timing.Attempts, time.Since(attemptStart))
+		}
+		if t.exposeAttemptHeader != "" && res != nil {
+			if res.Header == nil {
+				res.Header = http.Header{}
+			}
+			res.Header.Set(t.exposeAttemptHeader, strconv.Itoa(attemptCount))
+		}
+		if tracer != nil {
+			t.connTraceRecorder(Attempt{Count: attemptCount, Req: reqWithTimeout, Res: res, Err: err, Values: values}, tracer.result())
+		}
+		if replay != nil {
+			if rerr := replay.readErr(); rerr != nil {
+				discardAttempt(res, cancel)
+				return nil, fmt.Errorf("%w: %s", ErrReplayingBody, rerr)
+			}
+		}
+		if t.sizeRecorder != nil {
+			res = t.recordSize(Attempt{Count: attemptCount, Req: req, Res: res, Err: err, Values: values}, sentSize)
+		}
+		if t.maxTotalDownload > 0 {
+			// the body of the previous attempt was drained or closed before this one was made
+			if download != nil {
+				downloaded += download.n
+				download = nil
+			}
+			if res != nil {
+				download = &countingReader{ReadCloser: res.Body}
+				res.Body = download
+			}
+		}
+		if holdingRetry {
+			<-t.retrySem
+			holdingRetry = false
+		}
+
+		// a response that may be inspected is read into memory, so that it can be read again
+		if t.bufferResponseOn != nil && res != nil && t.bufferResponseOn(res.StatusCode) {
+			if berr := bufferResponse(res); berr != nil {
+				res, err = nil, fmt.Errorf("error buffering response body: %w", berr)
+			}
+		}
 
-		if preventRetry || attemptCount-1 >= maxRetries {
-			return injectCancelReader(res, cancel), err
+		// a successful response may still be structurally invalid
+		var invalidErr error
+		if t.responseValidator != nil && err == nil && res != nil && res.StatusCode >= 200 && res.StatusCode < 300 {
+			invalidErr = t.responseValidator(res)
+			rewindResponse(res)
 		}
 
+		retriesExhausted := attemptCount-1 >= maxRetries
+		if res != nil {
+			if limit, ok := maxRetriesPerStatus[res.StatusCode]; ok {
+				retriesExhausted = statusRetries[res.StatusCode] >= limit
+				if t.hardMaxRetries != nil && attemptCount-1 >= *t.hardMaxRetries {
+					retriesExhausted = true
+				}
+			}
+		}
+		// the server may lower the number of retries, but never raise it
+		if t.responseRetryHeader != "" && res != nil {
+			if n, perr := strconv.Atoi(strings.TrimSpace(res.Header.Get(t.responseRetryHeader))); perr == nil && n >= 0 {
+				if limit := attemptCount - 1 + n; serverMaxRetries < 0 || limit < serverMaxRetries {
+					serverMaxRetries = limit
+				}
+			}
+		}
+		if serverMaxRetries >= 0 && attemptCount-1 >= serverMaxRetries {
+			retriesExhausted = true
+		}
+		if group != nil && err == nil && invalidErr == nil && res != nil && res.StatusCode >= 200 && res.StatusCode < 300 {
+			group.Succeed()
+		}
+		gateClosed := t.retryGate != nil && !t.retryGate()
+		groupSucceeded := group != nil && group.Succeeded()
+		if preventRetry && emptyCheck != nil && emptyCheck.empty() {
+			preventRetry = false
+			req.Body = http.NoBody
+		}
+		emptyCheck = nil
+		// the final attempt is only reported as exhausted if it would otherwise be retried,
+		// so the decision must be made first
+		if preventRetry || gateClosed || groupSucceeded || (retriesExhausted && !t.retriesExhaustedErr) {
+			return t.finishAttempt(res, err, invalidErr, cancel)
+		}
+
+		// trailers are only populated once the body has been read to EOF
+		var retryOnTrailer bool
+		if retryOnTrailerFn != nil && err == nil && res != nil {
+			body, rerr := io.ReadAll(res.Body)
+			res.Body.Close()
+			if rerr != nil {
+				res, err = nil, rerr
+			} else {
+				res.Body = io.NopCloser(bytes.NewReader(body))
+				retryOnTrailer = retryOnTrailerFn(res.Trailer)
+			}
+		}
+
+		reason = ""
 		attempt := Attempt{
-			Count: attemptCount,
-			Req:   req,
-			Res:   res,
-			Err:   err,
+			Count:     attemptCount,
+			Req:       req,
+			Res:       res,
+			Err:       err,
+			Values:    values,
+			LastDelay: lastDelay,
+			reason:    &reason,
+			host:      reqWithTimeout.URL.Host,
+			started:   attemptStart,
+
+			assumeIdempotent: assumeIdempotent,
+			retryAfterParser: t.retryAfterParser,
+			redactedHeaders:  t.redactedHeaders,
 		}
+		attempt.Overloaded = t.overloadSignal == nil || t.overloadSignal(attempt)
 
-		shouldRetry := shouldRetryFn(attempt)
+		var shouldRetry, skipDelay bool
+		if invalidErr != nil {
+			shouldRetry = guessIdempotent(attempt, defaultIdempotentMethods)
+			reason = RetryReasonInvalidResponse
+		} else {
+			if shouldRetryResultFn != nil {
+				result := shouldRetryResultFn(attempt)
+				shouldRetry, skipDelay = result.Retry, result.SkipDelay
+			} else {
+				shouldRetry = shouldRetryFn(attempt)
+			}
+			if !shouldRetry && retryOnTrailer {
+				shouldRetry = guessIdempotent(attempt, defaultIdempotentMethods)
+				reason = RetryReasonTrailer
+			}
+		}
+		attempt.Reason = reason
 		if !shouldRetry {
-			return injectCancelReader(res, cancel), err
+			return t.finishAttempt(res, err, invalidErr, cancel)
+		}
+		if retriesExhausted {
+			return t.finishExhausted(attemptCount, res, err, invalidErr, cancel)
+		}
+
+		// the body of this attempt counts as far as the ShouldRetryFn read it
+		if t.maxTotalDownload > 0 {
+			total := downloaded
+			if download != nil {
+				total += download.n
+			}
+			if total > t.maxTotalDownload {
+				return t.finishAttempt(res, err, invalidErr, cancel)
+			}
+		}
+
+		// an attempt that finished in its grace period is returned rather than retried, since
+		// the request's context no longer allows a retry
+		if t.cancellationGrace > 0 && ctx.Err() != nil {
+			return t.finishAttempt(res, err, invalidErr, cancel)
+		}
+
+		// only checked once a retry is wanted, since checking may not be free
+		if t.retryPrecondition != nil && !t.retryPrecondition(ctx) {
+			return t.finishAttempt(res, err, invalidErr, cancel)
+		}
+
+		// a server down for maintenance may ask for a wait that isn't worth sleeping through
+		if t.maxRetryAfter > 0 && res != nil {
+			if retryAfter, ok := attempt.RetryAfter(); ok && retryAfter > t.maxRetryAfter {
+				if t.onLongRetryAfter != nil {
+					t.onLongRetryAfter(attempt, retryAfter)
+				}
+				return t.finishAttempt(res, err, invalidErr, cancel)
+			}
+		}
+
+		var delay time.Duration
+		if !skipDelay {
+			if backoff == nil {
+				backoff = delayFnBackoff(delayFn)
+				if newBackoff != nil {
+					backoff = newBackoff()
+				}
+				backoff.Reset()
+			}
+			if t.retryAfterRecorder != nil && res != nil {
+				if v := res.Header.Get("Retry-After"); v != "" {
+					if _, ok := parseRetryAfterWith(t.retryAfterParser, v); !ok {
+						t.retryAfterRecorder(attempt, &RetryAfterParseError{Value: v})
+					}
+				}
+			}
+			delay = backoff.Next(attempt)
+		}
+		if t.delayRecorder != nil {
+			t.delayRecorder(attempt, delay)
+		}
+		if retryAfterCap > 0 && delay > retryAfterCap && res != nil {
+			if _, ok := attempt.RetryAfter(); ok {
+				delay = retryAfterCap
+			}
+		}
+
+		if !headerDeadline.IsZero() && time.Now().Add(delay).After(headerDeadline) {
+			return t.finishAttempt(res, err, invalidErr, cancel)
+		}
+
+		// don't start an attempt that the request context's deadline won't let finish
+		if t.skipDoomed {
+			if deadline, ok := ctx.Deadline(); ok {
+				needed := t.minRoundTrip
+				if attemptTimeout > needed {
+					needed = attemptTimeout
+				}
+				if time.Until(deadline)-delay < needed {
+					return t.finishAttempt(res, err, invalidErr, cancel)
+				}
+			}
+		}
+
+		// retries are only counted against the limits once nothing else rules them out, so a
+		// retry that is abandoned doesn't use up a slot or budget that another request needs
+		if t.retrySem != nil && attempt.Overloaded {
+			select {
+			case t.retrySem <- struct{}{}:
+				holdingRetry = true
+			default: // too many retries in flight, give up on this one
+				if t.onThrottle != nil {
+					t.onThrottle(attempt)
+				}
+				return t.finishAttempt(res, err, invalidErr, cancel)
+			}
+		}
+		if t.retryBudget != nil && attempt.Overloaded && !t.retryBudget.tryRetry() {
+			if t.onThrottle != nil {
+				t.onThrottle(attempt)
+			}
+			return t.finishAttempt(res, err, invalidErr, cancel)
 		}
 
-		delay := delayFn(attempt)
 		if br != nil {
 			if _, serr := br.Seek(0, 0); serr != nil {
-				return injectCancelReader(res, cancel), fmt.Errorf("%w: %s", ErrSeekingBody, err)
+				discardAttempt(res, cancel)
+				return nil, fmt.Errorf("%w: %s", ErrSeekingBody, serr)
 			}
 			reqWithTimeout.Body = io.NopCloser(br)
 		}
+		if replayWithSeek {
+			if _, serr := seeker.Seek(seekStart, io.SeekStart); serr != nil {
+				discardAttempt(res, cancel)
+				return nil, fmt.Errorf("%w: %s", ErrSeekingBody, serr)
+			}
+			replay = &replayReader{ReadCloser: io.NopCloser(seeker)}
+			req.Body = replay
+		}
+		if replayWithGetBody {
+			body, gerr := req.GetBody()
+			if gerr != nil {
+				discardAttempt(res, cancel)
+				return nil, fmt.Errorf("%w: %s", ErrReplayingBody, gerr)
+			}
+			replay = &replayReader{ReadCloser: body}
+			req.Body = replay
+		}
+
+		if len(t.failoverHosts) > 0 && guessIdempotent(attempt, defaultIdempotentMethods) {
+			failovers++
+		}
+		if t.addressRotation && IsDialErr(err) && guessIdempotent(attempt, defaultIdempotentMethods) {
+			rotations++
+		}
 
+		var lastStatus int
 		if res != nil {
-			_, _ = io.Copy(io.Discard, res.Body)
+			lastStatus = res.StatusCode
+			if maxRetriesPerStatus != nil {
+				statusRetries[res.StatusCode]++
+			}
+			if connReusePolicy == ConnectionReuseDrain {
+				_, _ = io.Copy(io.Discard, res.Body)
+			}
 			res.Body.Close()
 		}
 
 		// going for another attempt, cancel the context of the attempt that was just made
-		cancel()
+		if cancel != nil {
+			cancel()
+		}
+		lastDelay = delay
 
+		delayStart := time.Now()
 		select {
 		case <-time.After(delay):
-			// do nothing, just loop again
+			if timing != nil {
+				timing.Delays = append(timing.Delays, time.Since(delayStart))
+			}
+		case <-groupDone: // happens if another request in the group succeeds
+			if timing != nil {
+				timing.Delays = append(timing.Delays, time.Since(delayStart))
+			}
+			if replayWithGetBody {
+				req.Body.Close()
+			}
+			return nil, &RetriesInterruptedError{
+				Attempts:   attemptCount,
+				StatusCode: lastStatus,
+				Err:        ErrRetryGroupSucceeded,
+			}
 		case <-req.Context().Done(): // happens if the parent context expires
-			return nil, req.Context().Err()
+			if timing != nil {
+				timing.Delays = append(timing.Delays, time.Since(delayStart))
+			}
+			// the body from GetBody was created for the retry that will now never be made
+			if replayWithGetBody {
+				req.Body.Close()
+			}
+			return nil, &RetriesInterruptedError{
+				Attempts:   attemptCount,
+				StatusCode: lastStatus,
+				Err:        req.Context().Err(),
+			}
+		}
+	}
+}
+
+// recordSize reports the size of an attempt to the size recorder. Since the response body
+// is a stream, its size is only known once it has been closed, which happens either when
+// Transport drains it before a retry or when the caller closes it.
+func (t *Transport) recordSize(attempt Attempt, sent int64) *http.Response {
+	if attempt.Res == nil {
+		t.sizeRecorder(attempt, sent, 0)
+		return nil
+	}
+
+	attempt.Res.Body = &countingReader{
+		ReadCloser: attempt.Res.Body,
+		onClose: func(received int64) {
+			t.sizeRecorder(attempt, sent, received)
+		},
+	}
+	return attempt.Res
+}
+
+// peekEmptyBody reads a single byte from a request body of unknown length to determine if it
+// is empty. An empty body is closed and replaced with [http.NoBody]. Otherwise the returned
+// body still yields the byte that was read.
+func peekEmptyBody(body io.ReadCloser) (io.ReadCloser, bool, error) {
+	var b [1]byte
+	n, err := io.ReadFull(body, b[:])
+	if err == io.EOF {
+		body.Close()
+		return http.NoBody, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(b[:n]), body),
+		Closer: body,
+	}, false, nil
+}
+
+// newIdempotencyKey generates a random (version 4) UUID to use as an idempotency key, reading
+// from r, or from crypto/rand if r is nil.
+func newIdempotencyKey(r io.Reader) (string, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+
+	var b [16]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// parseDeadlineHeader parses the value of a deadline header, which can either be an
+// absolute deadline in milliseconds since the Unix epoch, or a duration such as "1.5s"
+// relative to now.
+func parseDeadlineHeader(v string, now time.Time) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(d), true
+	}
+
+	return time.Time{}, false
+}
+
+// seekableBody returns body as an [io.ReadSeeker] if it can be rewound, along with its
+// current offset and the number of bytes remaining from there. It returns nil if body
+// does not implement [io.Seeker], or if seeking fails, as it does for pipes.
+func seekableBody(body io.ReadCloser) (io.ReadSeeker, int64, int64) {
+	rs, ok := body.(io.ReadSeeker)
+	if !ok {
+		return nil, 0, 0
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, 0
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, 0
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, 0
+	}
+
+	return rs, start, end - start
+}
+
+// attempt makes a single round trip using the internal roundtripper. If it panics, the
+// attempt's context is canceled and the request body closed so that nothing is leaked,
+// then the panic is either propagated or converted to an error.
+func (t *Transport) attempt(req *http.Request, cancel context.CancelFunc) (res *http.Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if cancel != nil {
+				cancel()
+			}
+			if req.Body != nil {
+				req.Body.Close()
+			}
+
+			if !t.recoverPanics {
+				panic(r)
+			}
+			res, err = nil, fmt.Errorf("%w: %v", ErrRoundTripperPanicked, r)
 		}
+	}()
+
+	return t.rt.RoundTrip(req)
+}
+
+// discardAttempt releases the resources of an attempt whose response won't be returned to
+// the caller, because the request is being abandoned with an error.
+func discardAttempt(res *http.Response, cancel context.CancelFunc) {
+	if res != nil {
+		res.Body.Close()
+	}
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// finishExhausted prepares the outcome of a final attempt that would have been retried if
+// retries weren't exhausted, for [WithRetriesExhaustedError]. The response is still
+// returned, unless it was rejected by the validator configured with [WithResponseValidator].
+func (t *Transport) finishExhausted(attempts int, res *http.Response, err, invalidErr error, cancel context.CancelFunc) (*http.Response, error) {
+	if invalidErr != nil {
+		discardAttempt(res, cancel)
+		res, err = nil, invalidErr
+	}
+
+	rewindResponse(res)
+	res, terr := t.transformResponse(res, cancel)
+	if terr != nil {
+		return res, terr
+	}
+	exhaustedErr := &RetriesExhaustedError{Attempts: attempts, Response: res, Err: err}
+	if res != nil {
+		exhaustedErr.StatusCode = res.StatusCode
+	}
+	return res, exhaustedErr
+}
+
+// finishAttempt prepares the outcome of the final attempt to be returned to the caller. A
+// response that was rejected by the validator configured with [WithResponseValidator] is
+// closed, and the validator's error is returned in its place.
+func (t *Transport) finishAttempt(res *http.Response, err, invalidErr error, cancel context.CancelFunc) (*http.Response, error) {
+	if invalidErr != nil {
+		discardAttempt(res, cancel)
+		return nil, invalidErr
+	}
+
+	rewindResponse(res)
+	res, terr := t.transformResponse(res, cancel)
+	if terr != nil {
+		return res, terr
+	}
+	return res, err
+}
+
+// transformResponse applies the transformer configured with [WithResponseTransformer] to the
+// final response, if there is one. The body of whatever response it returns is then wrapped
+// so that the attempt's context is canceled once it is closed.
+func (t *Transport) transformResponse(res *http.Response, cancel context.CancelFunc) (*http.Response, error) {
+	if t.responseTransformer == nil || res == nil {
+		return injectCancelReader(res, cancel), nil
+	}
+
+	res, err := t.responseTransformer(res)
+	if res == nil && cancel != nil {
+		cancel()
 	}
+	return injectCancelReader(res, cancel), err
 }